@@ -10,3 +10,5 @@ cd $GOPATH
 go build -o CreateConfig createconfig
 go build -o Upgrade LaunchUpgrade
 go build -o CreateGraph CreateGraph
+go build -o ConvertConfig ConvertConfig
+go build -o PinHostKeys PinHostKeys
@@ -4,6 +4,7 @@ source ./vars.sh
 
 rm -rf $GOPATH/src/softwareupgrade/vendor/github.com
 rm -rf $GOPATH/src/softwareupgrade/vendor/golang.org
+rm -rf $GOPATH/src/softwareupgrade/vendor/go.yaml.in
 rm -rf $GOPATH/pkg
 rm -rf $GOPATH/bin
 
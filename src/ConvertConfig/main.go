@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"softwareupgrade"
+	"strings"
+)
+
+// convert reads the input configuration, and writes it in the output format to the output file, or to stdout
+func convert(inputFilename, outputFilename, outputFormat string) (err error) {
+	inputFormat, err := softwareupgrade.GetConfigFormat(inputFilename)
+	if err != nil {
+		return
+	}
+	if outputFormat == "" {
+		if outputFilename == "" {
+			return fmt.Errorf("-format is required when -output is not specified")
+		}
+		if outputFormat, err = softwareupgrade.GetConfigFormat(outputFilename); err != nil {
+			return
+		}
+	}
+	data, err := softwareupgrade.ReadDataFromFile(inputFilename)
+	if err != nil {
+		return
+	}
+	converted, err := softwareupgrade.ConvertConfig(data, inputFormat, strings.ToLower(outputFormat))
+	if err != nil {
+		return fmt.Errorf("Unable to convert %s, error: %v", inputFilename, err)
+	}
+	if outputFilename == "" {
+		_, err = os.Stdout.Write(converted)
+		return
+	}
+	_, err = softwareupgrade.SaveDataToFile(outputFilename, converted)
+	return
+}
+
+func main() {
+	var inputFilename, outputFilename, outputFormat string
+	flag.StringVar(&inputFilename, "input", "", "Filename of the configuration to convert, in JSON, YAML or TOML format according to its extension (.json, .yaml, .yml or .toml)")
+	flag.StringVar(&outputFilename, "output", "", "Filename to write the converted configuration to, the configuration is written to the console if not specified")
+	flag.StringVar(&outputFormat, "format", "", "json|yaml|toml, the format to convert to, defaults to the format given by the extension of -output")
+	flag.Parse()
+
+	if inputFilename == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := convert(inputFilename, outputFilename, outputFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
@@ -11,6 +11,8 @@ const (
 	appActionDeleteRollback
 	appActionRollback
 	appActionResumeUpgrade
+	appActionPlan
+	appActionValidate
 
 	appActionMax // all appAction enumerations should be added before this
 )
@@ -21,6 +23,6 @@ func (action tAction) isValidAction() (result bool) {
 }
 
 func (action tAction) String() (result string) {
-	result = []string{"Unknown", "Upgrade", "Add", "Delete", "Rollback", "Resume", "Max"}[action]
+	result = []string{"Unknown", "Upgrade", "Add", "Delete", "Rollback", "Resume", "Plan", "Validate", "Max"}[action]
 	return
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+type (
+	// tDownBudget tracks the nodes of a software group that are stopped, so that no more than
+	// tolerance nodes are down at the same time. Nodes that didn't come back up keep their place in the budget.
+	tDownBudget struct {
+		softwareGroup string
+		tolerance     int
+		downNodes     map[string]bool
+		mutex         sync.Mutex
+	}
+)
+
+func newDownBudget(softwareGroup string, tolerance int) *tDownBudget {
+	return &tDownBudget{
+		softwareGroup: softwareGroup,
+		tolerance:     tolerance,
+		downNodes:     make(map[string]bool),
+	}
+}
+
+// acquire reserves a place in the budget for the node before it's stopped.
+// Returns an error if stopping the node would exceed the fault tolerance of the group.
+func (budget *tDownBudget) acquire(node string) error {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	if len(budget.downNodes) >= budget.tolerance {
+		return fmt.Errorf("refusing to stop node: %s, %d node(s) of software group: %s are already down %v, fault tolerance is %d",
+			node, len(budget.downNodes), budget.softwareGroup, budget.downNodeList(), budget.tolerance)
+	}
+	budget.downNodes[node] = true
+	return nil
+}
+
+// release returns the node's place in the budget once it's back up.
+func (budget *tDownBudget) release(node string) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	delete(budget.downNodes, node)
+}
+
+func (budget *tDownBudget) downNodeList() (result []string) {
+	for node := range budget.downNodes {
+		result = append(result, node)
+	}
+	return
+}
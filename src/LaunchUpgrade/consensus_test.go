@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"softwareupgrade"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownBudget_Contention(t *testing.T) {
+	const tolerance = 2
+	budget := newDownBudget("Quorum-Validators", tolerance)
+	var (
+		wg                  sync.WaitGroup
+		down, maxDown, runs int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			for budget.acquire(node) != nil {
+				time.Sleep(time.Millisecond)
+			}
+			current := atomic.AddInt32(&down, 1)
+			for {
+				highest := atomic.LoadInt32(&maxDown)
+				if current <= highest || atomic.CompareAndSwapInt32(&maxDown, highest, current) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			atomic.AddInt32(&down, -1)
+			atomic.AddInt32(&runs, 1)
+			budget.release(node)
+		}(fmt.Sprintf("node%d", i))
+	}
+	wg.Wait()
+	if runs != 20 {
+		t.Fatalf("Expected every node to acquire the budget, got %d", runs)
+	}
+	if maxDown > tolerance {
+		t.Fatalf("Expected at most %d nodes down at the same time, got %d", tolerance, maxDown)
+	}
+	if len(budget.downNodes) != 0 {
+		t.Fatalf("Expected no nodes down once released, got %v", budget.downNodeList())
+	}
+}
+
+func TestDownBudget_LeftDown(t *testing.T) {
+	budget := newDownBudget("Quorum-Validators", 2)
+	if err := budget.acquire("node1"); err != nil {
+		t.Fatal(err)
+	}
+	// node1 didn't start again, so its place isn't released
+	if err := budget.acquire("node2"); err != nil {
+		t.Fatal(err)
+	}
+	budget.release("node2")
+	if err := budget.acquire("node3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := budget.acquire("node4"); err == nil {
+		t.Fatal("A node shouldn't be stopped while node1 is left down and node3 is stopped")
+	}
+}
+
+func TestProcessGroup_LeftDownHoldsBudget(t *testing.T) {
+	defer func(savedAction tAction, savedMode string) { action, mode = savedAction, savedMode }(action, mode)
+	action, mode = appActionRollback, "rollback"
+	defer softwareupgrade.ClearSSHConfigCache()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A rollback continues after a node fails to start, so the next node is refused as the node left down holds the budget
+	nodes := []*testNode{startTestNode(t), startTestNode(t), startTestNode(t), startTestNode(t)}
+	var addresses []string
+	for _, node := range nodes {
+		defer node.close()
+		addresses = append(addresses, node.address())
+	}
+	session := newTestCanarySession(t, dir, softwareupgrade.UpgradeInfo{StopCmd: "stop", StartCmd: "false"}, addresses...)
+	session.config.Common.Canary = nil
+	session.config.Common.MaxParallel = map[string]int{"Quorum-Validators": 4}
+	session.config.Common.Consensus = map[string]softwareupgrade.ConsensusInfo{"Quorum-Validators": {FaultTolerance: 1}}
+	for _, address := range addresses {
+		session.rollbackSession.RollbackInfo.AddNodeSoftware(address, "quorum")
+	}
+	_, err = session.processGroup("Quorum-Validators", addresses, []string{"quorum"})
+	if err == nil {
+		t.Fatal("Nodes should be refused while a node is left down")
+	}
+	var processed int
+	for _, node := range nodes {
+		processed += int(atomic.LoadInt32(&node.connections))
+	}
+	if processed != 1 {
+		t.Fatalf("Only the node left down should have been stopped, but %d nodes were", processed)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"softwareupgrade"
+	"time"
+)
+
+// waitHealthy polls each of the given health checks on the node in turn, until it passes or its timeout expires.
+// Returns the last error of the first health check that didn't pass.
+func waitHealthy(node string, conn softwareupgrade.NodeConnection, healthChecks []softwareupgrade.HealthCheck) (err error) {
+	for i := range healthChecks {
+		check := &healthChecks[i]
+		checker := check.NewChecker(conn)
+		deadline := time.Now().Add(check.GetTimeout())
+		for {
+			if err = checker.Check(); err == nil {
+				DebugLog.Println(`Node %s: health check "%s" passed`, node, check)
+				break
+			}
+			DebugLog.Debugln("Node %s: %v", node, err)
+			if Terminated() || time.Now().Add(check.GetInterval()).After(deadline) {
+				return
+			}
+			time.Sleep(check.GetInterval())
+		}
+	}
+	return
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// tPrompter asks the operator whether to process each node or software group in interactive mode.
+	// Only one question is asked at a time, nodes processed in parallel wait for their turn.
+	tPrompter struct {
+		mutex    sync.Mutex
+		scope    string
+		asking   bool
+		input    io.Reader
+		lines    chan string
+		wanted   chan bool                        // asks readLines to read the next answer
+		pending  bool                             // an answer has been asked for, but not received yet
+		decision func(scope, name, choice string) // records the choice in the session files
+	}
+)
+
+const (
+	choiceProceed  = "proceed"
+	choiceSkip     = "skip"
+	choiceAbort    = "abort"
+	choiceContinue = "continue without asking"
+
+	scopeNode  = "node"
+	scopeGroup = "group"
+)
+
+// newPrompter creates a prompter that asks before each node or each software group, according to scope.
+// The answers are read from input.
+func newPrompter(scope string, input io.Reader, decision func(scope, name, choice string)) (result *tPrompter, err error) {
+	scope = strings.ToLower(scope)
+	if scope != scopeNode && scope != scopeGroup {
+		return nil, fmt.Errorf("unknown interactive scope: %s", scope)
+	}
+	result = &tPrompter{scope: scope, asking: true, input: input, lines: make(chan string), wanted: make(chan bool, 1), decision: decision}
+	go result.readLines()
+	return
+}
+
+// readLines reads the operator's answers, the channel is closed when the input ends.
+// Each answer is only read once it's wanted, so that the input can be read by others, eg, passphrase prompts.
+func (prompter *tPrompter) readLines() {
+	scanner := bufio.NewScanner(prompter.input)
+	for range prompter.wanted {
+		if !scanner.Scan() {
+			break
+		}
+		prompter.lines <- scanner.Text()
+	}
+	close(prompter.lines)
+}
+
+// confirm shows the actions and asks the operator whether to proceed with the node or software group.
+// It returns choiceProceed without asking if the scope is different, or if the operator chose to continue without asking.
+func (prompter *tPrompter) confirm(scope, name string, actions []string) (choice string) {
+	if prompter == nil || prompter.scope != scope {
+		return choiceProceed
+	}
+	prompter.mutex.Lock()
+	defer prompter.mutex.Unlock()
+	if !prompter.asking || Terminated() {
+		return choiceProceed
+	}
+
+	DebugLog.Println("Planned %s actions for %s: %s", mode, scope, name)
+	for _, line := range actions {
+		DebugLog.Println("  %s", line)
+	}
+	choice = prompter.ask(fmt.Sprintf("%s %s: [p]roceed, [s]kip, [a]bort or [c]ontinue without asking? ", strings.Title(scope), name))
+	DebugLog.Println("Operator chose to %s for %s: %s", choice, scope, name)
+	if prompter.decision != nil {
+		prompter.decision(scope, name, choice)
+	}
+	switch choice {
+	case choiceContinue:
+		{
+			prompter.asking = false
+			choice = choiceProceed
+		}
+	case choiceAbort:
+		{
+			requestTermination()
+		}
+	}
+	return
+}
+
+// ask waits for a valid answer. A termination request, or the end of the input, is an abort.
+func (prompter *tPrompter) ask(question string) string {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	fmt.Print(question)
+	for {
+		if !prompter.pending {
+			prompter.pending = true
+			prompter.wanted <- true
+		}
+		select {
+		case line, ok := <-prompter.lines:
+			{
+				prompter.pending = false
+				if !ok {
+					fmt.Println()
+					return choiceAbort
+				}
+				switch strings.ToLower(strings.TrimSpace(line)) {
+				case "p", "proceed", "y", "yes":
+					return choiceProceed
+				case "s", "skip", "n", "no":
+					return choiceSkip
+				case "a", "abort", "q", "quit":
+					return choiceAbort
+				case "c", "continue":
+					return choiceContinue
+				}
+				fmt.Print(question)
+			}
+		case <-ticker.C:
+			{
+				if Terminated() {
+					fmt.Println()
+					return choiceAbort
+				}
+			}
+		}
+	}
+}
+
+// describeNodeActions describes what the current mode would do with each software on the node
+func (session *tUpgradeSession) describeNodeActions(node string, groupSoftware []string) (result []string) {
+	for _, software := range groupSoftware {
+		if action == appActionRollback && !session.rollbackSession.RollbackInfo.ExistsNodeSoftware(node, software) {
+			continue
+		}
+		if session.resumeUpgrade && !session.failedUpgradeInfo.ExistsNodeSoftware(node, software) {
+			continue
+		}
+		nodeInfo := session.config.GetNodeUpgradeInfo(node, software)
+		result = append(result, fmt.Sprintf("Software: %s", software))
+		stopsAndStarts := action != appActionDeleteRollback && action != appActionAdd
+		if stopsAndStarts && nodeInfo.StopCmd != "" {
+			result = append(result, fmt.Sprintf("  Stop: %s", nodeInfo.StopCmd))
+		}
+		var indexes []string
+		for index := range nodeInfo.Copy {
+			indexes = append(indexes, index)
+		}
+		sort.Strings(indexes)
+		for _, index := range indexes {
+			upgradeStruct := nodeInfo.Copy[index]
+			if upgradeStruct.SourceFilePath == "" {
+				continue
+			}
+			switch action {
+			case appActionRollback:
+				{
+					result = append(result, fmt.Sprintf("  Restore: %s", upgradeStruct.DestFilePath))
+				}
+			case appActionDeleteRollback:
+				{
+					result = append(result, fmt.Sprintf("  Delete the backup of: %s", upgradeStruct.DestFilePath))
+				}
+			default:
+				{
+					result = append(result, fmt.Sprintf("  Copy: %s -> %s", upgradeStruct.SourceFilePath, upgradeStruct.DestFilePath))
+				}
+			}
+		}
+		if stopsAndStarts && nodeInfo.StartCmd != "" {
+			result = append(result, fmt.Sprintf("  Start: %s", nodeInfo.StartCmd))
+		}
+	}
+	return
+}
+
+// describeGroupActions describes what the current mode would do on each node of the software group
+func (session *tUpgradeSession) describeGroupActions(groupNodes, groupSoftware []string) (result []string) {
+	for _, node := range groupNodes {
+		result = append(result, fmt.Sprintf("Node: %s", node))
+		for _, line := range session.describeNodeActions(node, groupSoftware) {
+			result = append(result, "  "+line)
+		}
+	}
+	return
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPrompter_Confirm(t *testing.T) {
+	defer atomic.StoreInt32(&terminated, 0)
+	tests := []struct {
+		name       string
+		scope      string
+		input      string
+		choices    []string
+		terminated bool
+	}{
+		{"yes", scopeNode, "y\nyes\np\n", []string{choiceProceed, choiceProceed, choiceProceed}, false},
+		{"no", scopeNode, "n\nskip\n", []string{choiceSkip, choiceSkip}, false},
+		{"skip group", scopeGroup, "maybe\ns\n", []string{choiceSkip}, false},
+		{"continue", scopeNode, "c\n", []string{choiceProceed, choiceProceed}, false},
+		{"quit", scopeNode, "q\n", []string{choiceAbort}, true},
+		{"end of input", scopeGroup, "", []string{choiceAbort}, true},
+	}
+	for _, test := range tests {
+		atomic.StoreInt32(&terminated, 0)
+		var decisions []string
+		prompter, err := newPrompter(test.scope, strings.NewReader(test.input), func(scope, name, choice string) {
+			if scope != test.scope || name != "node1" {
+				t.Errorf("%s: unexpected decision recorded for %s: %s", test.name, scope, name)
+			}
+			decisions = append(decisions, choice)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, expected := range test.choices {
+			if choice := prompter.confirm(test.scope, "node1", []string{"Software: quorum"}); choice != expected {
+				t.Errorf("%s: answer %d should be %s, got %s", test.name, i+1, expected, choice)
+			}
+		}
+		if test.name == "continue" && len(decisions) != 1 {
+			t.Errorf("%s: the operator shouldn't be asked again, but was asked %d times", test.name, len(decisions))
+		}
+		if Terminated() != test.terminated {
+			t.Errorf("%s: expected termination to be %v", test.name, test.terminated)
+		}
+	}
+}
+
+func TestPrompter_OtherScope(t *testing.T) {
+	prompter, err := newPrompter(scopeGroup, strings.NewReader("s\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if choice := prompter.confirm(scopeNode, "node1", nil); choice != choiceProceed {
+		t.Fatalf("Nodes shouldn't be asked about when the scope is group, got %s", choice)
+	}
+	if choice := prompter.confirm(scopeGroup, "Quorum-Validators", nil); choice != choiceSkip {
+		t.Fatalf("The answer should still be available for the group, got %s", choice)
+	}
+	if _, err = newPrompter("cluster", strings.NewReader(""), nil); err == nil {
+		t.Fatal("An unknown scope should be refused")
+	}
+}
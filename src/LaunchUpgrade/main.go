@@ -17,19 +17,31 @@ var (
 	userSSLcertContent                                       []byte
 	appStatus                                                string
 	debugLogFilename, failedNodesFilename                    string
-	rollbackInfoFilename                                     string
-	jsonFilename                                             string
+	rollbackInfoFilename, journalFilename                    string
+	jsonFilenames                                            softwareupgrade.StringList
+	printConfigFormat                                        string
 	debug                                                    bool
 	disableNodeVerification, disableFileVerification, dryRun bool
 	disableTargetDirVerification                             bool
 	mode, rollbackSuffix                                     string
 	action                                                   tAction
+	maxParallel                                              int
+	planFormat, planFilename                                 string
+	interactive                                              bool
+	interactiveScope                                         string
+	skipUnchanged                                            bool
+	artifactCacheDir                                         string
+	selectGroups, selectNodes, selectSoftware, selectExclude softwareupgrade.StringList
+	selection                                                *softwareupgrade.Selection
 )
 
 func upgradeOrRollback(jsonContents []byte) {
 	var upgradeconfig softwareupgrade.UpgradeConfig
 	// Parse the JSON
-	json.Unmarshal(jsonContents, &upgradeconfig)
+	if err := json.Unmarshal(jsonContents, &upgradeconfig); err != nil {
+		DebugLog.Println("Unable to parse the JSON configuration, use -mode=validate to find the problems, error: %v", err)
+		return
+	}
 
 	if upgradeconfig.Common.SSHTimeout != "" {
 		parsedTimeout, err := time.ParseDuration(upgradeconfig.Common.SSHTimeout)
@@ -44,6 +56,21 @@ func upgradeOrRollback(jsonContents []byte) {
 
 	DebugLog.Println("This session PID: %d rollback file: %s", os.Getpid(), rollbackInfoFilename)
 
+	// Placeholders that can't be expanded must not reach the shell
+	if err := upgradeconfig.ValidateCommands(); err != nil {
+		DebugLog.Println("Unable to expand the placeholders in the commands.")
+		DebugLog.Printf("%v", err)
+		return
+	}
+
+	// Fetch the files specified by URLs, only the modes that copy files need them
+	if action != appActionRollback && action != appActionDeleteRollback {
+		if err := upgradeconfig.ResolveArtifacts(softwareupgrade.NewDownloader(artifactCacheDir)); err != nil {
+			DebugLog.Printf("%v\n", err)
+			return
+		}
+	}
+
 	if !disableFileVerification {
 		if err := upgradeconfig.VerifyFilesExist(); err != nil {
 			DebugLog.Printf("%v\n", err)
@@ -52,13 +79,30 @@ func upgradeOrRollback(jsonContents []byte) {
 		DebugLog.Println("All source files verified.")
 	}
 
-	// GroupNames is the name given to each combination of software
-	SoftwareGroupNames := upgradeconfig.GetGroupNames()
+	// GroupNames is the name given to each combination of software, in the order they are processed
+	SoftwareGroupNames, err := upgradeconfig.GetGroupExecutionOrder()
+	if err != nil {
+		DebugLog.Println("Unable to determine the order of the software groups.")
+		DebugLog.Printf("%v", err)
+		return
+	}
 	DebugLog.Println("%d groups defined: %v", len(SoftwareGroupNames), SoftwareGroupNames)
 
 	// Nodes contains the list of the nodes to upgrade.
-	nodes := upgradeconfig.GetNodes()
+	nodes := upgradeconfig.GetSelectedNodes(selection, SoftwareGroupNames)
 	DebugLog.Println("%d nodes found: %v", len(nodes), nodes)
+	if !selection.IsEmpty() {
+		if len(nodes) == 0 {
+			DebugLog.Println("No node and software match the selection.")
+			return
+		}
+		for _, softwareGroup := range SoftwareGroupNames {
+			if groupNodes := upgradeconfig.GetSelectedGroupNodes(selection, softwareGroup); len(groupNodes) > 0 {
+				DebugLog.Println("Selected software group: %s, nodes: %v, software: %v", softwareGroup, groupNodes,
+					upgradeconfig.GetSelectedGroupSoftware(selection, softwareGroup))
+			}
+		}
+	}
 
 	if !disableNodeVerification {
 		// Verify all nodes can be looked up using IP address.
@@ -67,7 +111,7 @@ func upgradeOrRollback(jsonContents []byte) {
 			failCount, nodeCount int
 		)
 		for _, node := range nodes {
-			_, err := net.LookupIP(node)
+			_, err := net.LookupIP(softwareupgrade.SSHHost(node))
 			if err != nil {
 				msg = fmt.Sprintf("%sCan't resolve %s\n", msg, node)
 				failCount++
@@ -84,9 +128,9 @@ func upgradeOrRollback(jsonContents []byte) {
 		}
 	}
 
-	if !disableTargetDirVerification || action == appActionAdd {
+	if (!disableTargetDirVerification && action != appActionPlan) || action == appActionAdd {
 		// Only perform directory verification if there is at least 1 node
-		if nodeCount := upgradeconfig.GetNodeCount(); nodeCount > 0 {
+		if nodeCount := len(nodes); nodeCount > 0 {
 			DebugLog.Println("Verifying target directories, please wait.")
 
 			// Verify all target directories exist. This is also an opportunity
@@ -107,11 +151,11 @@ func upgradeOrRollback(jsonContents []byte) {
 				if Terminated() {
 					break
 				}
-				// Look up the software for each softwareGroup
-				groupSoftware := upgradeconfig.GetGroupSoftware(softwareGroup)
+				// Look up the selected software for each softwareGroup
+				groupSoftware := upgradeconfig.GetSelectedGroupSoftware(selection, softwareGroup)
 
-				// Get the nodes for this group
-				groupNodes := upgradeconfig.GetGroupNodes(softwareGroup)
+				// Get the selected nodes for this group
+				groupNodes := upgradeconfig.GetSelectedGroupNodes(selection, softwareGroup)
 				for _, node := range groupNodes {
 					if Terminated() {
 						break
@@ -121,7 +165,7 @@ func upgradeOrRollback(jsonContents []byte) {
 					}
 					for _, software := range groupSoftware {
 						nodeInfo := upgradeconfig.GetNodeUpgradeInfo(node, software)
-						sshConfig := softwareupgrade.NewSSHConfig(nodeInfo.SSHUserName, nodeInfo.SSHCert, node)
+						sshConfig := softwareupgrade.NewSSHConfigFromInfo(nodeInfo.SSHInfo, node)
 						for _, dirInfo := range nodeInfo.Copy {
 							remoteDir := path.Dir(dirInfo.DestFilePath)
 							hostDir := fmt.Sprintf("%s-%s", node, remoteDir)
@@ -177,6 +221,14 @@ func upgradeOrRollback(jsonContents []byte) {
 		}
 	}
 
+	// Plan mode only inspects the nodes, so nothing is stopped, started or written to the nodes or the session files
+	if action == appActionPlan {
+		if err := writePlan(&upgradeconfig, SoftwareGroupNames); err != nil {
+			DebugLog.Println("Unable to write the plan due to %v", err)
+		}
+		return
+	}
+
 	failedUpgradeInfo := softwareupgrade.NewFailedUpgradeInfo()
 	rollbackSession := softwareupgrade.NewRollbackSession(rollbackSuffix)
 
@@ -195,7 +247,13 @@ func upgradeOrRollback(jsonContents []byte) {
 		}
 
 		// Save the rollback data for either deletion, or rollback
-		if !rollbackSession.RollbackInfo.Empty() {
+		rolledBack := rollbackSession.RolledBackInfo != nil && !rollbackSession.RolledBackInfo.Empty()
+		hasVersions := (action == appActionUpgrade || action == appActionResumeUpgrade) &&
+			rollbackSession.Versions != nil && !rollbackSession.Versions.Empty()
+		if rolledBack {
+			DebugLog.Println("Software rolled back automatically: %v", rollbackSession.RolledBackInfo.FailedNodeSoftware)
+		}
+		if !rollbackSession.RollbackInfo.Empty() || rolledBack || hasVersions || len(rollbackSession.Decisions) > 0 {
 			data, err := json.Marshal(rollbackSession)
 			if err == nil {
 				softwareupgrade.SaveDataToFile(rollbackInfoFilename, data)
@@ -236,6 +294,33 @@ func upgradeOrRollback(jsonContents []byte) {
 				DebugLog.Printf("Can't resume upgrade as %s doesn't exist.\n", failedNodesFilename)
 				return
 			}
+			data, err := softwareupgrade.ReadDataFromFile(failedNodesFilename)
+			if err == nil {
+				err = json.Unmarshal(data, failedUpgradeInfo)
+			}
+			if err != nil {
+				DebugLog.Printf("Unable to read data from the failed nodes session due to error: %v", err)
+				failedUpgradeInfo.Clear()
+				return
+			}
+			// Continue with the rollback information of the interrupted session
+			if softwareupgrade.FileExists(rollbackInfoFilename) {
+				data, err = softwareupgrade.ReadDataFromFile(rollbackInfoFilename)
+				if err == nil {
+					err = json.Unmarshal(data, &rollbackSession)
+				}
+				if err != nil {
+					DebugLog.Printf("Unable to read data from the rollback session due to error: %v", err)
+					rollbackSession.RollbackInfo.Clear()
+					failedUpgradeInfo.Clear()
+					return
+				}
+				// Without the journal, the backups are still named after the interrupted session
+				if rollbackSession.SessionSuffix != "" {
+					rollbackSuffix = rollbackSession.SessionSuffix
+					softwareupgrade.SetBackupSuffix(rollbackSuffix)
+				}
+			}
 			resumeUpgrade = true
 		}
 	case appActionRollback:
@@ -263,7 +348,7 @@ func upgradeOrRollback(jsonContents []byte) {
 			if softwareupgrade.FileExists(failedNodesFilename) {
 				data, err := softwareupgrade.ReadDataFromFile(failedNodesFilename)
 				if err == nil {
-					err = json.Unmarshal(data, &failedUpgradeInfo.FailedNodeSoftware)
+					err = json.Unmarshal(data, failedUpgradeInfo)
 					resumeUpgrade = err == nil && len(failedUpgradeInfo.FailedNodeSoftware) > 0
 				} else {
 					DebugLog.Printf("Unable to read data from the failed nodes session due to error: %v", err)
@@ -272,8 +357,8 @@ func upgradeOrRollback(jsonContents []byte) {
 				DebugLog.Println("Building node software list...")
 				// Build the failedNodeSoftware list since this is a new session
 				for _, softwareGroup := range SoftwareGroupNames {
-					groupSoftware := upgradeconfig.GetGroupSoftware(softwareGroup)
-					groupNodes := upgradeconfig.GetGroupNodes(softwareGroup)
+					groupSoftware := upgradeconfig.GetSelectedGroupSoftware(selection, softwareGroup)
+					groupNodes := upgradeconfig.GetSelectedGroupNodes(selection, softwareGroup)
 					for _, node := range groupNodes {
 						for _, software := range groupSoftware {
 							failedUpgradeInfo.AddNodeSoftware(node, software)
@@ -284,6 +369,21 @@ func upgradeOrRollback(jsonContents []byte) {
 			}
 		}
 	}
+	// A rollback session saved before versions were recorded has no version information
+	if rollbackSession.Versions == nil {
+		rollbackSession.Versions = softwareupgrade.NewVersionInfo()
+	}
+
+	var journal *softwareupgrade.Journal
+	if action == appActionUpgrade || action == appActionResumeUpgrade {
+		var err error
+		if journal, err = openJournal(journalFilename); err != nil {
+			DebugLog.Println("Unable to load the journal %s due to %v", journalFilename, err)
+			return
+		}
+		rollbackSession.SessionSuffix = rollbackSuffix
+	}
+
 	defer func() {
 		// shows upgrade/rollback aborted/completed on app completion
 		DebugLog.Println("%s %s", mode, appStatus)
@@ -295,147 +395,70 @@ func upgradeOrRollback(jsonContents []byte) {
 		return
 	}
 
+	session := &tUpgradeSession{
+		config:            &upgradeconfig,
+		failedUpgradeInfo: failedUpgradeInfo,
+		rollbackSession:   rollbackSession,
+		resumeUpgrade:     resumeUpgrade,
+		journal:           journal,
+		unchangedInfo:     softwareupgrade.NewFailedUpgradeInfo(),
+	}
+	if interactive {
+		var err error
+		recordDecision := func(scope, name, choice string) {
+			rollbackSession.AddDecision(mode, scope, name, choice)
+		}
+		if session.prompter, err = newPrompter(interactiveScope, os.Stdin, recordDecision); err != nil {
+			DebugLog.Println("%v", err)
+			return
+		}
+	}
+	defer func() {
+		if !session.unchangedInfo.Empty() {
+			DebugLog.Println("Software unchanged: %v", session.unchangedInfo.FailedNodeSoftware)
+		}
+	}()
+
+	var canaryFailed bool
+	stoppedGroups := make(map[string]bool) // groups that didn't complete, so the groups depending on them are skipped
+
 	for _, softwareGroup := range SoftwareGroupNames {
-		// Look up the software for each softwareGroup
-		groupSoftware := upgradeconfig.GetGroupSoftware(softwareGroup)
+		if dependency := stoppedDependency(&upgradeconfig, softwareGroup, stoppedGroups); dependency != "" {
+			DebugLog.Println("Skipping software group: %s as it depends on software group: %s which didn't complete", softwareGroup, dependency)
+			stoppedGroups[softwareGroup] = true
+			continue
+		}
 
-		// Get the nodes for this group
-		groupNodes := upgradeconfig.GetGroupNodes(softwareGroup)
+		// Look up the selected software for each softwareGroup
+		groupSoftware := upgradeconfig.GetSelectedGroupSoftware(selection, softwareGroup)
+
+		// Get the selected nodes for this group
+		groupNodes := upgradeconfig.GetSelectedGroupNodes(selection, softwareGroup)
 		if len(groupNodes) > 0 {
 			var doPause bool
 			DebugLog.Printf("Performing %s for software group: %s\n", mode, softwareGroup)
-			for _, node := range groupNodes {
-				if len(groupSoftware) == 0 {
-					continue
-				}
-				if Terminated() {
+			actions := session.describeGroupActions(groupNodes, groupSoftware)
+			if session.prompter.confirm(scopeGroup, softwareGroup, actions) == choiceSkip {
+				DebugLog.Println("Skipping software group: %s as chosen by the operator", softwareGroup)
+				stoppedGroups[softwareGroup] = true
+				continue
+			}
+			if Terminated() { // the operator chose to abort
+				break
+			}
+			if len(groupSoftware) > 0 {
+				started, err := session.processGroup(softwareGroup, groupNodes, groupSoftware)
+				if err == errCanaryFailed {
+					DebugLog.Println("Aborting %s, the failed nodes are saved in %s", mode, failedNodesFilename)
+					canaryFailed = true
 					break
 				}
-				doPause = true
-				for _, software := range groupSoftware {
-					if Terminated() {
-						break
-					}
-
-					// If this is a rollback, and the node and software doesn't exist
-					// in the rollback data, then skip to the next one
-					if action == appActionRollback {
-						if !rollbackSession.RollbackInfo.ExistsNodeSoftware(node, software) {
-							continue
-						}
-					}
-
-					nodeInfo := upgradeconfig.GetNodeUpgradeInfo(node, software)
-
-					// If this is a resume operation, and the node and software doesn't
-					// exist in the failedUpgradeInfo then skip the current node and software.
-					if resumeUpgrade {
-						if !failedUpgradeInfo.ExistsNodeSoftware(node, software) {
-							DebugLog.Println("Skipping software %s for node %s", software, node)
-							continue
-						}
-					}
-
-					// This message should be appropriate for different modes
-					// It should be 1) Adding software %s to node %s
-					//              2) Rolling back software %s for node %s
-					//              3) Upgrading node %s with software %s
-					//              4) Resuming upgrade for node %s with software %s
-					//              5) Deleting software %s from node %s
-					var actionMsg string
-					switch action {
-					case appActionAdd:
-						{
-							actionMsg = fmt.Sprintf("Adding software: %s to node: %s", software, node)
-						}
-					case appActionDeleteRollback:
-						{
-							actionMsg = fmt.Sprintf("Deleting rollback for software: %s from node: %s", software, node)
-						}
-					case appActionResumeUpgrade:
-						{
-							actionMsg = fmt.Sprintf("Resuming upgrade for node: %s with software: %s", node, software)
-						}
-					case appActionRollback:
-						{
-							actionMsg = fmt.Sprintf("Rolling back software: %s for node: %s", software, node)
-						}
-					case appActionUpgrade:
-						{
-							actionMsg = fmt.Sprintf("Upgrading node: %s with software: %s\n", node, software)
-						}
-					}
-					DebugLog.Println(actionMsg)
-					sshConfig := softwareupgrade.NewSSHConfig(nodeInfo.SSHUserName, nodeInfo.SSHCert, node)
-
-					// Only stop the software if it's not Delete Rollback and not Add
-					if action != appActionDeleteRollback && action != appActionAdd {
-						// Stop the running software, upgrade it, then start the software
-						StopCmd := nodeInfo.StopCmd
-						StopResult, err := sshConfig.Run(StopCmd)
-						if err != nil { // If stop failed, skip the upgrade!
-							DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStop, err)
-							continue
-						}
-						DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStop, StopResult)
-					}
-
-					if !dryRun {
-						switch action {
-						case appActionAdd:
-							{
-								err := nodeInfo.RunAdd(sshConfig)
-								if err == nil {
-									DebugLog.Println("Added software: %s to node: %s successfully", software, node)
-								} else {
-									DebugLog.Println("Failed to add software %s to node: %s", software, node)
-								}
-							}
-						case appActionDeleteRollback:
-							{
-								err := nodeInfo.RunDeleteRollback(sshConfig, rollbackSuffix)
-								if err != nil {
-									DebugLog.Println("Failed to delete rollback for node: %s, software: %s due to %v", node, software, err)
-								} else {
-									DebugLog.Println("Deleted rollback for node: %s, software: %s", node, software)
-								}
-							}
-						case appActionRollback:
-							{
-
-								err := nodeInfo.RunRollback(sshConfig, rollbackSuffix)
-								if err != nil {
-									DebugLog.Println("Rollback failed for node: %s, software: %s due to %v", node, software, err)
-								} else {
-									DebugLog.Println("Rolled back node: %s with software: %s successfully", node, software)
-									rollbackSession.RollbackInfo.RemoveNodeSoftware(node, software)
-								}
-							}
-						case appActionUpgrade:
-							{
-								err := nodeInfo.RunUpgrade(sshConfig) // the upgrade needs to either move or overwrite the older version
-								if err != nil {
-									DebugLog.Println("Error during RunUpgrade: %v", err)
-								} else {
-									DebugLog.Println("Upgraded node: %s with software %s successfully!", node, software)
-									failedUpgradeInfo.RemoveNodeSoftware(node, software)
-									rollbackSession.RollbackInfo.AddNodeSoftware(node, software)
-								}
-							}
-						}
-					}
-
-					// Only start the software if it's not a delete rollback
-					if action != appActionDeleteRollback && action != appActionAdd {
-						StartCmd := nodeInfo.StartCmd
-						StartResult, err := sshConfig.Run(StartCmd)
-						if err != nil {
-							DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStart, err)
-							continue
-						}
-						DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStart, StartResult)
-					}
+				if err != nil {
+					DebugLog.Println("Stopped %s for software group: %s as %v", mode, softwareGroup, err)
+					stoppedGroups[softwareGroup] = true
+					continue
 				}
+				doPause = started > 0
 			}
 			if Terminated() {
 				break
@@ -452,31 +475,93 @@ func upgradeOrRollback(jsonContents []byte) {
 		}
 	}
 
-	if !Terminated() {
+	if !Terminated() && !canaryFailed {
 		appStatus = "completed"
 	}
 	softwareupgrade.ClearSSHConfigCache()
 }
 
+// stoppedDependency returns the name of a software group that softwareGroup depends on that didn't complete, if any.
+func stoppedDependency(upgradeconfig *softwareupgrade.UpgradeConfig, softwareGroup string, stoppedGroups map[string]bool) string {
+	for _, dependency := range upgradeconfig.GetGroupDependencies(softwareGroup) {
+		if stoppedGroups[dependency] {
+			return dependency
+		}
+	}
+	return ""
+}
+
+// validateConfig reports every problem in the JSON configuration, and returns false if there's any problem
+func validateConfig(jsonContents []byte) bool {
+	problems := softwareupgrade.ValidateConfig(jsonContents)
+	for _, problem := range problems {
+		DebugLog.Println("%s", problem)
+	}
+	if len(problems) > 0 {
+		DebugLog.Println("Found %d problem(s) in %s", len(problems), jsonFilenames.String())
+		return false
+	}
+	DebugLog.Println("No problems found in %s", jsonFilenames.String())
+	return true
+}
+
+// printConfig prints the effective configuration in the format given by -print-config
+func printConfig(jsonContents []byte) bool {
+	contents, err := softwareupgrade.ConvertConfig(jsonContents, softwareupgrade.CConfigFormatJSON, strings.ToLower(printConfigFormat))
+	if err == nil {
+		_, err = os.Stdout.Write(contents)
+	}
+	if err != nil {
+		DebugLog.Println("Unable to print the configuration, error: %v", err)
+		return false
+	}
+	return true
+}
+
 func main() {
-	fmt.Println(softwareupgrade.CEximchainUpgradeTitle)
+	// registered first so that it runs after the other deferred functions
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
 
 	rollbackSuffix = softwareupgrade.GetBackupSuffix()
 	defaultRollbackName := fmt.Sprintf("~/Upgrade-Rollback-%s.session", rollbackSuffix)
 	defaultFailedNodesFilename := fmt.Sprintf("~/Upgrade-Failed-%s.session", rollbackSuffix)
+	defaultJournalFilename := fmt.Sprintf("~/Upgrade-Journal-%s.session", rollbackSuffix)
 
-	flag.StringVar(&mode, "mode", "upgrade", "mode (add|resume-upgrade|upgrade|rollback|delete-rollback)")
+	flag.StringVar(&mode, "mode", "upgrade", "mode (add|plan|resume-upgrade|upgrade|rollback|delete-rollback|validate)")
 	flag.BoolVar(&debug, "debug", false, "Specifies debug mode")
 	flag.StringVar(&debugLogFilename, "debug-log", `~/Upgrade-debug.log`, "Specifies the debug log filename where logs are written to")
-	flag.StringVar(&jsonFilename, "json", "", "Specifies the JSON configuration file to load nodes from")
+	flag.Var(&jsonFilenames, "json", "Specifies the configuration file to load nodes from, in JSON, YAML or TOML format according to its extension (.json, .yaml, .yml or .toml). Specify it more than once to merge several files, later files override earlier ones")
+	flag.StringVar(&printConfigFormat, "print-config", "", "Prints the effective configuration merged from every configuration file in the given format (json|yaml|toml), and exits")
 	flag.StringVar(&failedNodesFilename, "failed-nodes", defaultFailedNodesFilename, "Specifes the file to load/save nodes that failed to upgrade")
 	flag.StringVar(&rollbackInfoFilename, "rollback-filename", defaultRollbackName, "Specifies the rollback filename for this session")
+	flag.StringVar(&journalFilename, "journal", defaultJournalFilename, "Specifies the file to load/save the progress of each node's upgrade, so that an interrupted upgrade resumes from the exact step")
 	flag.BoolVar(&disableNodeVerification, "disable-node-verification", false, "Disables node IP resolution verification")
 	flag.BoolVar(&disableFileVerification, "disable-file-verification", false, "Disables source file existence verification")
 	flag.BoolVar(&disableTargetDirVerification, "disable-target-dir-verification", false, "Disables target directory existence verification")
+	flag.IntVar(&maxParallel, "max-parallel", 0, "Specifies the number of nodes in a software group to process at the same time, overrides max_parallel in the configuration")
+	flag.StringVar(&artifactCacheDir, "artifact-cache", "~/Upgrade-Cache", "Specifies the directory where files specified by URLs are downloaded to")
+	flag.BoolVar(&skipUnchanged, "skip-unchanged", true, "Skips the software on nodes that already have the same files, without stopping it")
+	flag.StringVar(&planFormat, "plan-format", "text", "Specifies the format of the plan in plan mode (text|json)")
+	flag.StringVar(&planFilename, "plan-output", "", "Specifies the file to write the plan to in plan mode, the plan is written to the console if not specified")
+	flag.Var(&selectGroups, "groups", "Restricts the software groups processed to those matching the comma separated globs, or the regular expression enclosed in slashes, eg, /^Quorum-/")
+	flag.Var(&selectNodes, "nodes", "Restricts the nodes processed to those matching the comma separated globs, or the regular expression enclosed in slashes")
+	flag.Var(&selectSoftware, "software", "Restricts the software processed to those matching the comma separated globs, or the regular expression enclosed in slashes")
+	flag.Var(&selectExclude, "exclude", "Excludes the software groups, nodes and software matching the comma separated globs, or the regular expression enclosed in slashes")
+	flag.BoolVar(&interactive, "interactive", false, "Shows the planned actions and asks whether to proceed, skip or abort before each node, or each software group, as specified by -interactive-scope")
+	flag.StringVar(&interactiveScope, "interactive-scope", scopeNode, "Specifies whether interactive mode asks before each node or each software group (node|group)")
 	flag.BoolVar(&dryRun, "dry-run", true, "Enables testing mode, doesn't perform actual action, but starts and stops the software running on remote nodes")
 	flag.Parse()
 
+	// the printed configuration must be usable as a configuration file
+	if printConfigFormat == "" {
+		fmt.Println(softwareupgrade.CEximchainUpgradeTitle)
+	}
+
 	switch strings.ToLower(mode) {
 	case "add":
 		{
@@ -498,13 +583,24 @@ func main() {
 		{
 			action = appActionUpgrade
 		}
+	case "plan":
+		{
+			action = appActionPlan
+		}
+	case "validate":
+		{
+			action = appActionValidate
+		}
 	}
 
-	// Ensures that JSONFilename is provided by user
+	// Ensures that at least one configuration file is provided by user
 	// and that mode must either be rollback or upgrade and that the given
-	// JSON configuration file must exist
-	if len(os.Args) <= 1 || jsonFilename == "" || !action.isValidAction() ||
-		!softwareupgrade.FileExists(jsonFilename) {
+	// configuration files must exist
+	configFilesExist := len(jsonFilenames) > 0
+	for _, jsonFilename := range jsonFilenames {
+		configFilesExist = configFilesExist && softwareupgrade.FileExists(jsonFilename)
+	}
+	if len(os.Args) <= 1 || !configFilesExist || !action.isValidAction() {
 		flag.PrintDefaults()
 		return
 	}
@@ -521,22 +617,44 @@ func main() {
 	DebugLog.Debugln(softwareupgrade.CEximchainUpgradeTitle)
 	DebugLog.EnablePrintConsole()
 
-	// Read JSON configuration file
-	if expandedJSONFilename, err := softwareupgrade.Expand(jsonFilename); err == nil {
-		jsonFilename = expandedJSONFilename
-	} else {
-		DebugLog.Println("Unable to interpret/parse %s due to %v", jsonFilename, err)
+	var err error
+	if selection, err = softwareupgrade.NewSelection(selectGroups, selectNodes, selectSoftware, selectExclude); err != nil {
+		DebugLog.Println("%v", err)
+		exitCode = 1
 		return
 	}
 
-	if jsonContents, err := softwareupgrade.ReadDataFromFile(jsonFilename); err == nil {
+	// Read the configuration files, YAML and TOML are translated into JSON
+	for i := range jsonFilenames {
+		if expandedJSONFilename, err := softwareupgrade.Expand(jsonFilenames[i]); err == nil {
+			jsonFilenames[i] = expandedJSONFilename
+		} else {
+			DebugLog.Println("Unable to interpret/parse %s due to %v", jsonFilenames[i], err)
+			return
+		}
+	}
+
+	if jsonContents, err := softwareupgrade.LoadConfigFiles(jsonFilenames); err == nil {
+		if printConfigFormat != "" {
+			if !printConfig(jsonContents) {
+				exitCode = 1
+			}
+			return
+		}
+		if action == appActionValidate {
+			if !validateConfig(jsonContents) {
+				exitCode = 1
+			}
+			return
+		}
 		EnableSignalHandler()
 
 		// Start processing the upgrade/rollback, etc...
 		upgradeOrRollback(jsonContents)
 		TerminateSignalHandler()
 	} else {
-		DebugLog.Println(`Error reading from JSON configuration file: "%s", error: %v`, jsonFilename, err)
+		DebugLog.Println(`Error reading from configuration files: "%s", error: %v`, jsonFilenames.String(), err)
+		exitCode = 1
 	}
 
 }
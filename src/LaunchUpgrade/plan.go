@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"softwareupgrade"
+	"strings"
+)
+
+// writePlan inspects every node and software of the given groups, and writes what upgrading them would do
+// in the format and to the file specified by the command line.
+func writePlan(config *softwareupgrade.UpgradeConfig, groupNames []string) (err error) {
+	format := strings.ToLower(planFormat)
+	if format != "json" && format != "text" {
+		return fmt.Errorf("unknown plan format: %s", planFormat)
+	}
+	var plan softwareupgrade.UpgradePlan
+	localHasher := softwareupgrade.NewLocalHostHasher()
+	for _, softwareGroup := range groupNames {
+		groupSoftware := config.GetSelectedGroupSoftware(selection, softwareGroup)
+		for _, node := range config.GetSelectedGroupNodes(selection, softwareGroup) {
+			for _, software := range groupSoftware {
+				if Terminated() {
+					return fmt.Errorf("%s terminated", mode)
+				}
+				nodeInfo := config.GetNodeUpgradeInfo(node, software)
+				sshConfig := softwareupgrade.NewSSHConfigFromInfo(nodeInfo.SSHInfo, node)
+				DebugLog.Println("Planning node: %s, software: %s", node, software)
+				plan.Software = append(plan.Software, nodeInfo.Plan(sshConfig, localHasher, node, software))
+			}
+		}
+	}
+
+	output := os.Stdout
+	if planFilename != "" {
+		filename, err := softwareupgrade.Expand(planFilename)
+		if err != nil {
+			return err
+		}
+		if output, err = os.Create(filename); err != nil {
+			return err
+		}
+		defer output.Close()
+	}
+
+	switch format {
+	case "json":
+		{
+			var data []byte
+			if data, err = json.MarshalIndent(plan, "", "  "); err == nil {
+				_, err = fmt.Fprintln(output, string(data))
+			}
+		}
+	case "text":
+		{
+			err = plan.WriteText(output)
+		}
+	}
+	return
+}
+
+// logRenderedTemplates logs the files that would be rendered for the node, so that they can be reviewed in dry-run mode
+func logRenderedTemplates(node string, nodeInfo *softwareupgrade.NodeInfoContainer) {
+	for _, upgradeStruct := range nodeInfo.Copy {
+		if !upgradeStruct.IsTemplate() {
+			continue
+		}
+		rendered, err := nodeInfo.RenderTemplate(upgradeStruct.SourceFilePath)
+		if err != nil {
+			DebugLog.Println("Node %s: unable to render %s due to %v", node, upgradeStruct.SourceFilePath, err)
+			continue
+		}
+		DebugLog.Println("Node %s: %s rendered for %s:\n%s", node, upgradeStruct.SourceFilePath, upgradeStruct.DestFilePath, string(rendered))
+	}
+}
@@ -3,18 +3,24 @@ package main
 import (
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 )
 
 var (
 	signalCh   chan os.Signal
-	terminated bool
+	terminated int32 // set to 1 once termination is requested, accessed atomically as nodes are processed in parallel
 )
 
 // Terminated returns whether user has requested termination via Ctrl C,
 // or other means
 func Terminated() bool {
-	return terminated
+	return atomic.LoadInt32(&terminated) != 0
+}
+
+// requestTermination requests termination as if the user pressed Ctrl C
+func requestTermination() {
+	atomic.StoreInt32(&terminated, 1)
 }
 
 // EnableSignalHandler watches for a termination request from the user
@@ -30,7 +36,7 @@ func EnableSignalHandler() {
 		if s != syscall.SIGQUIT {
 			DebugLog.Println("Please wait while finishing up...")
 		}
-		terminated = true
+		requestTermination()
 		return
 	}()
 }
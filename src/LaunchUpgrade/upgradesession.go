@@ -0,0 +1,461 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"softwareupgrade"
+	"time"
+)
+
+type (
+	// tUpgradeSession carries the state shared by all the nodes processed in this session.
+	// failedUpgradeInfo and rollbackSession are safe to be updated by multiple nodes at the same time.
+	tUpgradeSession struct {
+		config            *softwareupgrade.UpgradeConfig
+		failedUpgradeInfo *softwareupgrade.FailedUpgradeInfo
+		rollbackSession   *softwareupgrade.RollbackSession
+		resumeUpgrade     bool
+		journal           *softwareupgrade.Journal
+		unchangedInfo     *softwareupgrade.FailedUpgradeInfo // the software skipped because the node already has the same files
+		prompter          *tPrompter                         // asks the operator before each node or software group in interactive mode
+	}
+)
+
+var (
+	errCanaryFailed = errors.New("canary node(s) failed")
+)
+
+// processGroup performs the current mode's action on the nodes of a software group, returning the number of nodes started.
+// When upgrading a group with canary nodes, those are processed first and must stay healthy for the soak duration
+// before the rest of the group is processed, otherwise errCanaryFailed is returned.
+func (session *tUpgradeSession) processGroup(softwareGroup string, groupNodes, groupSoftware []string) (started int, err error) {
+	groupMaxParallel := session.config.GetGroupMaxParallel(softwareGroup)
+	if maxParallel > 0 {
+		groupMaxParallel = maxParallel
+	}
+
+	// In a group with a consensus constraint, nodes are only stopped while the number of nodes
+	// that are down stays within the group's fault tolerance.
+	var budget *tDownBudget
+	if action != appActionDeleteRollback && action != appActionAdd {
+		tolerance, constrained, toleranceErr := session.config.GetGroupFaultTolerance(softwareGroup)
+		if toleranceErr != nil {
+			return 0, toleranceErr
+		}
+		if constrained {
+			if tolerance < 1 {
+				return 0, fmt.Errorf("software group: %s can't tolerate any node being stopped", softwareGroup)
+			}
+			if groupMaxParallel > tolerance {
+				groupMaxParallel = tolerance
+			}
+			DebugLog.Println("Software group: %s tolerates %d node(s) being stopped at the same time", softwareGroup, tolerance)
+			budget = newDownBudget(softwareGroup, tolerance)
+		}
+	}
+
+	DebugLog.Println("Processing up to %d node(s) at the same time", groupMaxParallel)
+	work := func(node string) error {
+		switch session.prompter.confirm(scopeNode, node, session.describeNodeActions(node, groupSoftware)) {
+		case choiceSkip:
+			{
+				DebugLog.Println("Skipping node: %s as chosen by the operator", node)
+				return nil
+			}
+		case choiceAbort:
+			{
+				return fmt.Errorf("%s aborted by the operator", mode)
+			}
+		}
+		if budget != nil {
+			if err := budget.acquire(node); err != nil {
+				DebugLog.Println("%v", err)
+				return err
+			}
+		}
+		leftDown, err := session.processNode(node, groupSoftware)
+		if budget != nil && !leftDown {
+			budget.release(node)
+		}
+		return err
+	}
+
+	canaryNodes, otherNodes := session.config.GetGroupCanaryNodes(softwareGroup)
+	canaryNodes, otherNodes = selection.SelectNodes(canaryNodes), selection.SelectNodes(otherNodes)
+	if len(canaryNodes) > 0 && (action == appActionUpgrade || action == appActionResumeUpgrade) {
+		DebugLog.Println("Processing canary node(s) %v for software group: %s", canaryNodes, softwareGroup)
+		canaryStarted, failedNodes := runNodes(canaryNodes, groupMaxParallel, work)
+		started += canaryStarted
+		if len(failedNodes) > 0 {
+			DebugLog.Println("Canary node(s) %v are not healthy", failedNodes)
+			return started, errCanaryFailed
+		}
+		soak := session.config.Common.Canary[softwareGroup].Soak.Duration
+		DebugLog.Printf("Soaking canary node(s) for %s...", soak)
+		sleepUnlessTerminated(soak)
+		DebugLog.Println(" completed!")
+		if Terminated() {
+			return
+		}
+		if failedNodes = session.recheckHealth(canaryNodes, groupSoftware); len(failedNodes) > 0 {
+			DebugLog.Println("Canary node(s) %v didn't stay healthy", failedNodes)
+			return started, errCanaryFailed
+		}
+		groupNodes = otherNodes
+	}
+
+	otherStarted, failedNodes := runNodes(groupNodes, groupMaxParallel, work)
+	started += otherStarted
+	if len(failedNodes) > 0 {
+		err = fmt.Errorf("node(s) %v are not healthy", failedNodes)
+	}
+	return
+}
+
+// recheckHealth runs the health checks of every software in groupSoftware on the given nodes again.
+// Returns the nodes that aren't healthy.
+func (session *tUpgradeSession) recheckHealth(nodes []string, groupSoftware []string) (failedNodes []string) {
+	for _, node := range nodes {
+		for _, software := range groupSoftware {
+			nodeInfo := session.config.GetNodeUpgradeInfo(node, software)
+			if len(nodeInfo.HealthCheck) == 0 {
+				continue
+			}
+			sshConfig := softwareupgrade.NewSSHConfigFromInfo(nodeInfo.SSHInfo, node)
+			if err := waitHealthy(node, sshConfig, nodeInfo.HealthCheck); err != nil {
+				DebugLog.Println("Node %s: software %s is not healthy: %v", node, software, err)
+				session.recordUnhealthy(node, software)
+				failedNodes = append(failedNodes, node)
+				break
+			}
+		}
+	}
+	return
+}
+
+// autoRollback restores the files backed up while upgrading the software on the node in this session.
+// If successful, the node and software is recorded as rolled back instead of failed, and its journal entry is removed,
+// as there's no upgrade left to resume.
+func (session *tUpgradeSession) autoRollback(node, software string, nodeInfo *softwareupgrade.NodeInfoContainer, sshConfig *softwareupgrade.SSHConfig) (err error) {
+	DebugLog.Println("Rolling back software: %s for node: %s as its upgrade failed", software, node)
+	err = nodeInfo.RunRollback(sshConfig, softwareupgrade.GetBackupSuffix())
+	if err != nil {
+		DebugLog.Println("Rollback failed for node: %s, software: %s due to %v", node, software, err)
+		return
+	}
+	DebugLog.Println("Rolled back node: %s with software: %s successfully", node, software)
+	session.failedUpgradeInfo.RemoveNodeSoftware(node, software)
+	session.rollbackSession.RollbackInfo.RemoveNodeSoftware(node, software)
+	session.rollbackSession.RolledBackInfo.AddNodeSoftware(node, software)
+	if journalErr := session.journal.Remove(node, software); journalErr != nil {
+		DebugLog.Println("Node %s: software %s, failed to remove it from the journal due to %v", node, software, journalErr)
+	}
+	return
+}
+
+// recordUnhealthy keeps the node and software in the failed nodes so that it's retried when the upgrade is resumed
+func (session *tUpgradeSession) recordUnhealthy(node, software string) {
+	if action == appActionUpgrade || action == appActionResumeUpgrade {
+		session.failedUpgradeInfo.AddNodeSoftware(node, software)
+	}
+}
+
+// openJournal loads the journal of an interrupted session if it exists, otherwise a new journal is created.
+// When a journal is loaded, this session continues with the backup suffix of the interrupted session.
+func openJournal(filename string) (journal *softwareupgrade.Journal, err error) {
+	if !softwareupgrade.FileExists(filename) {
+		return softwareupgrade.NewJournal(filename, rollbackSuffix), nil
+	}
+	journal, err = softwareupgrade.LoadJournal(filename)
+	if err != nil {
+		return
+	}
+	if journal.SessionSuffix == "" {
+		journal.SessionSuffix = rollbackSuffix
+	}
+	rollbackSuffix = journal.SessionSuffix
+	softwareupgrade.SetBackupSuffix(rollbackSuffix)
+	DebugLog.Println("Loaded journal %s of session %s", filename, rollbackSuffix)
+	return
+}
+
+// recordStep records in the journal that the step has been completed for the node and software
+func (session *tUpgradeSession) recordStep(node, software, step string) {
+	if err := session.journal.Record(node, software, step); err != nil {
+		DebugLog.Println("Node %s: software %s, failed to record step %s in the journal due to %v", node, software, step, err)
+	}
+}
+
+// checkVersion records the version of the software after it's started.
+// Returns an error if the software has been upgraded, and the version isn't the target version.
+func (session *tUpgradeSession) checkVersion(node, software string, nodeInfo *softwareupgrade.NodeInfoContainer,
+	sshConfig *softwareupgrade.SSHConfig, upgraded bool) (err error) {
+	version, err := nodeInfo.GetVersion(sshConfig)
+	if err != nil {
+		if !upgraded || nodeInfo.TargetVersion == "" {
+			DebugLog.Println("Node %s: unable to get the version of software %s due to %v", node, software, err)
+			err = nil
+		}
+		return
+	}
+	DebugLog.Println("Node %s: software %s version after upgrade: %s", node, software, version)
+	session.rollbackSession.Versions.SetAfter(node, software, version)
+	if upgraded && nodeInfo.TargetVersion != "" && version != nodeInfo.TargetVersion {
+		err = fmt.Errorf("version %s doesn't match the target version %s", version, nodeInfo.TargetVersion)
+	}
+	return
+}
+
+// sleepUnlessTerminated sleeps for the given duration, returning early if termination has been requested.
+func sleepUnlessTerminated(d time.Duration) {
+	deadline := time.Now().Add(d)
+	for !Terminated() && time.Now().Before(deadline) {
+		remaining := time.Until(deadline)
+		if remaining > time.Second {
+			remaining = time.Second
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// processNode performs the current mode's action for every software in groupSoftware on the given node.
+// leftDown is true if any software failed to start again after being stopped.
+// Returns an error if any software didn't become healthy after being started, or when upgrading, if any software
+// failed to stop, upgrade or start, in which case the remaining software is skipped.
+// Software whose failed upgrade is rolled back, and that starts again, isn't an error.
+func (session *tUpgradeSession) processNode(node string, groupSoftware []string) (leftDown bool, err error) {
+	for _, software := range groupSoftware {
+		if Terminated() {
+			break
+		}
+
+		// If this is a rollback, and the node and software doesn't exist
+		// in the rollback data, then skip to the next one
+		if action == appActionRollback {
+			if !session.rollbackSession.RollbackInfo.ExistsNodeSoftware(node, software) {
+				continue
+			}
+		}
+
+		nodeInfo := session.config.GetNodeUpgradeInfo(node, software)
+
+		// If this is a resume operation, and the node and software doesn't
+		// exist in the failedUpgradeInfo then skip the current node and software.
+		if session.resumeUpgrade {
+			if !session.failedUpgradeInfo.ExistsNodeSoftware(node, software) {
+				DebugLog.Println("Skipping software %s for node %s", software, node)
+				continue
+			}
+		}
+
+		// This message should be appropriate for different modes
+		// It should be 1) Adding software %s to node %s
+		//              2) Rolling back software %s for node %s
+		//              3) Upgrading node %s with software %s
+		//              4) Resuming upgrade for node %s with software %s
+		//              5) Deleting software %s from node %s
+		var actionMsg string
+		switch action {
+		case appActionAdd:
+			{
+				actionMsg = fmt.Sprintf("Adding software: %s to node: %s", software, node)
+			}
+		case appActionDeleteRollback:
+			{
+				actionMsg = fmt.Sprintf("Deleting rollback for software: %s from node: %s", software, node)
+			}
+		case appActionResumeUpgrade:
+			{
+				actionMsg = fmt.Sprintf("Resuming upgrade for node: %s with software: %s", node, software)
+			}
+		case appActionRollback:
+			{
+				actionMsg = fmt.Sprintf("Rolling back software: %s for node: %s", software, node)
+			}
+		case appActionUpgrade:
+			{
+				actionMsg = fmt.Sprintf("Upgrading node: %s with software: %s\n", node, software)
+			}
+		}
+		DebugLog.Println(actionMsg)
+		sshConfig := softwareupgrade.NewSSHConfigFromInfo(nodeInfo.SSHInfo, node)
+
+		// When upgrading, the steps already recorded in the journal by an interrupted session are not repeated.
+		// Software that was started again after its upgrade failed is stopped again before the upgrade continues.
+		isUpgrade := action == appActionUpgrade || action == appActionResumeUpgrade
+		alreadyStopped := isUpgrade && session.journal.Stopped(node, software)
+		alreadyStarted := isUpgrade && session.journal.Completed(node, software, softwareupgrade.CStepStarted)
+		if alreadyStarted {
+			DebugLog.Println("Node %s: software %s has already been started", node, software)
+		} else if alreadyStopped {
+			DebugLog.Println("Node %s: software %s has already been stopped, continuing after step: %s",
+				node, software, session.journal.Get(node, software).Step)
+		}
+
+		// Record the version before the upgrade, the software is skipped if it's already at the target version
+		if isUpgrade && nodeInfo.VersionCmd != "" && !alreadyStopped {
+			version, err := nodeInfo.GetVersion(sshConfig)
+			if err != nil {
+				DebugLog.Println("Node %s: unable to get the version of software %s due to %v", node, software, err)
+			} else {
+				DebugLog.Println("Node %s: software %s version before upgrade: %s", node, software, version)
+				session.rollbackSession.Versions.SetBefore(node, software, version)
+				if nodeInfo.TargetVersion != "" && version == nodeInfo.TargetVersion {
+					DebugLog.Println("Node %s: software %s is already at version %s", node, software, version)
+					session.failedUpgradeInfo.RemoveNodeSoftware(node, software)
+					session.unchangedInfo.AddNodeSoftware(node, software)
+					continue
+				}
+			}
+		}
+
+		// Skip the software if the node already has the same files, so that it isn't needlessly restarted
+		if isUpgrade && skipUnchanged && !alreadyStopped {
+			unchanged, err := nodeInfo.IsUnchanged(sshConfig, softwareupgrade.NewLocalHostHasher())
+			if unchanged {
+				DebugLog.Println("Node %s: software %s is unchanged", node, software)
+				session.failedUpgradeInfo.RemoveNodeSoftware(node, software)
+				session.unchangedInfo.AddNodeSoftware(node, software)
+				continue
+			}
+			if err != nil {
+				DebugLog.Debugln("Node %s: unable to compare the files of software %s due to %v", node, software, err)
+			}
+		}
+
+		// Only stop the software if it's not Delete Rollback and not Add
+		if action != appActionDeleteRollback && action != appActionAdd && !alreadyStopped {
+			// Stop the running software, upgrade it, then start the software
+			StopCmd := nodeInfo.StopCmd
+			StopResult, err := sshConfig.Run(StopCmd)
+			if err != nil { // If stop failed, skip the upgrade!
+				DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStop, err)
+				if isUpgrade {
+					return false, fmt.Errorf("software %s failed to stop: %v", software, err)
+				}
+				continue
+			}
+			DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStop, StopResult)
+			if isUpgrade && !dryRun {
+				if err := session.journal.RecordStopped(node, software); err != nil {
+					DebugLog.Println("Node %s: software %s, failed to record step %s in the journal due to %v", node, software, softwareupgrade.CStepStopped, err)
+				}
+			}
+		}
+
+		var (
+			upgraded   bool  // whether the software has been upgraded on the node during this session
+			upgradeErr error // the software is started again before the failed upgrade is reported
+		)
+		if !dryRun {
+			switch action {
+			case appActionAdd:
+				{
+					err := nodeInfo.RunAdd(sshConfig)
+					if err == nil {
+						DebugLog.Println("Added software: %s to node: %s successfully", software, node)
+					} else {
+						DebugLog.Println("Failed to add software %s to node: %s", software, node)
+					}
+				}
+			case appActionDeleteRollback:
+				{
+					err := nodeInfo.RunDeleteRollback(sshConfig, rollbackSuffix)
+					if err != nil {
+						DebugLog.Println("Failed to delete rollback for node: %s, software: %s due to %v", node, software, err)
+					} else {
+						DebugLog.Println("Deleted rollback for node: %s, software: %s", node, software)
+					}
+				}
+			case appActionRollback:
+				{
+
+					err := nodeInfo.RunRollback(sshConfig, rollbackSuffix)
+					if err != nil {
+						DebugLog.Println("Rollback failed for node: %s, software: %s due to %v", node, software, err)
+					} else {
+						DebugLog.Println("Rolled back node: %s with software: %s successfully", node, software)
+						session.rollbackSession.RollbackInfo.RemoveNodeSoftware(node, software)
+					}
+				}
+			case appActionUpgrade, appActionResumeUpgrade:
+				{
+					// the upgrade needs to either move or overwrite the older version
+					err := nodeInfo.RunJournaledUpgrade(sshConfig, session.journal, node, software)
+					if err != nil {
+						DebugLog.Println("Error during RunUpgrade for node: %s, software: %s: %v", node, software, err)
+						upgradeErr = fmt.Errorf("software %s failed to upgrade: %v", software, err)
+						// a node that's rolled back, and started again, isn't failed
+						if nodeInfo.OnFailure == softwareupgrade.COnFailureRollback && session.autoRollback(node, software, nodeInfo, sshConfig) == nil {
+							upgradeErr = nil
+						}
+					} else {
+						DebugLog.Println("Upgraded node: %s with software %s successfully!", node, software)
+						session.failedUpgradeInfo.RemoveNodeSoftware(node, software)
+						session.rollbackSession.RollbackInfo.AddNodeSoftware(node, software)
+						upgraded = true
+					}
+				}
+			}
+		} else if isUpgrade || action == appActionAdd {
+			logRenderedTemplates(node, nodeInfo)
+		}
+
+		// Only start the software if it's not a delete rollback
+		if action != appActionDeleteRollback && action != appActionAdd {
+			if !alreadyStarted {
+				StartCmd := nodeInfo.StartCmd
+				StartResult, err := sshConfig.Run(StartCmd)
+				if err != nil {
+					DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStart, err)
+					if upgraded && nodeInfo.OnFailure == softwareupgrade.COnFailureRollback {
+						if session.autoRollback(node, software, nodeInfo, sshConfig) == nil {
+							StartResult, err = sshConfig.Run(StartCmd)
+							DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStart, fmt.Sprintf("%s %v", StartResult, err))
+						}
+					}
+					if err != nil {
+						leftDown = true
+						if isUpgrade {
+							session.recordUnhealthy(node, software)
+							return true, fmt.Errorf("software %s failed to start: %v", software, err)
+						}
+					}
+					if upgradeErr != nil {
+						return leftDown, upgradeErr
+					}
+					continue
+				}
+				DebugLog.Printf(softwareupgrade.CNodeMsgSSS, node, softwareupgrade.CStart, StartResult)
+				if upgraded {
+					session.recordStep(node, software, softwareupgrade.CStepStarted)
+				} else if isUpgrade && !dryRun && session.journal.Completed(node, software, softwareupgrade.CStepStopped) {
+					// the upgrade didn't complete, so the software must be stopped again when it's resumed
+					if err := session.journal.RecordRunning(node, software); err != nil {
+						DebugLog.Println("Node %s: software %s, failed to record that it's running in the journal due to %v", node, software, err)
+					}
+				}
+			}
+			if upgradeErr != nil {
+				return false, upgradeErr
+			}
+
+			if isUpgrade && nodeInfo.VersionCmd != "" {
+				if versionErr := session.checkVersion(node, software, nodeInfo, sshConfig, upgraded || alreadyStarted); versionErr != nil {
+					DebugLog.Println("Node %s: software %s failed: %v", node, software, versionErr)
+					session.recordUnhealthy(node, software)
+					return false, versionErr
+				}
+			}
+
+			if len(nodeInfo.HealthCheck) > 0 {
+				if healthErr := waitHealthy(node, sshConfig, nodeInfo.HealthCheck); healthErr != nil {
+					DebugLog.Println("Node %s: software %s is not healthy: %v", node, software, healthErr)
+					session.recordUnhealthy(node, software)
+					return true, healthErr
+				}
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"softwareupgrade"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testNode is an in-process SSH server that accepts any key, and runs no command.
+// Commands containing "false" exit with status 1, the others exit with status 0.
+type testNode struct {
+	listener    net.Listener
+	config      *ssh.ServerConfig
+	connections int32
+	mutex       sync.Mutex
+	commands    []string // the commands run, in order
+}
+
+func startTestNode(t *testing.T) (node *testNode) {
+	node = &testNode{config: &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}}
+	hostKey, _ := newTestSigner(t)
+	node.config.AddHostKey(hostKey)
+	var err error
+	if node.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := node.listener.Accept()
+			if err != nil {
+				return
+			}
+			go node.handleConn(conn)
+		}
+	}()
+	return
+}
+
+func (node *testNode) address() string {
+	return node.listener.Addr().String()
+}
+
+// ran returns the commands run so far, in order
+func (node *testNode) ran() []string {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+	return append([]string(nil), node.commands...)
+}
+
+func (node *testNode) close() {
+	node.listener.Close()
+}
+
+func (node *testNode) handleConn(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, node.config)
+	if err != nil {
+		return
+	}
+	atomic.AddInt32(&node.connections, 1)
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for request := range channelRequests {
+				if request.Type != "exec" {
+					request.Reply(false, nil)
+					continue
+				}
+				request.Reply(true, nil)
+				var command struct{ Command string }
+				ssh.Unmarshal(request.Payload, &command)
+				node.mutex.Lock()
+				node.commands = append(node.commands, command.Command)
+				node.mutex.Unlock()
+				var status uint32
+				if strings.Contains(command.Command, "false") {
+					status = 1
+				}
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+func newTestSigner(t *testing.T) (ssh.Signer, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+// newTestCanarySession creates an upgrade session for a software group whose first node is its canary
+func newTestCanarySession(t *testing.T, dir string, software softwareupgrade.UpgradeInfo, nodes ...string) *tUpgradeSession {
+	_, key := newTestSigner(t)
+	keyFilename := filepath.Join(dir, "id_rsa")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(keyFilename, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := &softwareupgrade.UpgradeConfig{}
+	config.Common.SSHInfo = softwareupgrade.SSHInfo{SSHCert: keyFilename, SSHUserName: "ubuntu", SSHHostKeyPolicy: softwareupgrade.CHostKeyPolicyOff}
+	config.Common.SoftwareGroup = map[string][]string{"Quorum-Validators": {"quorum"}}
+	config.Common.Canary = map[string]softwareupgrade.CanaryInfo{"Quorum-Validators": {Count: 1}}
+	config.SoftwareGroupNodes = map[string][]string{"Quorum-Validators": nodes}
+	config.Software = map[string]softwareupgrade.UpgradeInfo{"quorum": software}
+
+	session := &tUpgradeSession{
+		config:            config,
+		failedUpgradeInfo: softwareupgrade.NewFailedUpgradeInfo(),
+		rollbackSession:   softwareupgrade.NewRollbackSession("test"),
+		unchangedInfo:     softwareupgrade.NewFailedUpgradeInfo(),
+	}
+	for _, node := range nodes {
+		session.failedUpgradeInfo.AddNodeSoftware(node, "quorum")
+	}
+	return session
+}
+
+func TestProcessGroup_CanaryFailed(t *testing.T) {
+	defer func(savedAction tAction, savedMode string) { action, mode = savedAction, savedMode }(action, mode)
+	action, mode = appActionUpgrade, "upgrade"
+	softwareupgrade.SetSSHTimeout(5 * time.Second)
+	defer softwareupgrade.ClearSSHConfigCache()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		software softwareupgrade.UpgradeInfo
+	}{
+		{"upgrade", softwareupgrade.UpgradeInfo{StopCmd: "stop", StartCmd: "start",
+			Copy: map[string]softwareupgrade.UpgradeStruct{"1": {SourceFilePath: filepath.Join(dir, "missing"), DestFilePath: "/opt/quorum"}}}},
+		{"stop", softwareupgrade.UpgradeInfo{StopCmd: "false", StartCmd: "start"}},
+		{"start", softwareupgrade.UpgradeInfo{StopCmd: "stop", StartCmd: "false"}},
+	}
+	for _, test := range tests {
+		canary, other := startTestNode(t), startTestNode(t)
+		session := newTestCanarySession(t, dir, test.software, canary.address(), other.address())
+		groupNodes := session.config.GetGroupNodes("Quorum-Validators")
+		if _, err = session.processGroup("Quorum-Validators", groupNodes, []string{"quorum"}); err != errCanaryFailed {
+			t.Errorf("%s: a canary that fails to %s should abort the group, but the error is %v", test.name, test.name, err)
+		}
+		if connections := atomic.LoadInt32(&other.connections); connections != 0 {
+			t.Errorf("%s: the other node shouldn't be processed after the canary failed", test.name)
+		}
+		if !session.failedUpgradeInfo.ExistsNodeSoftware(canary.address(), "quorum") {
+			t.Errorf("%s: the canary should be kept in the failed nodes", test.name)
+		}
+		canary.close()
+		other.close()
+	}
+}
+
+func TestProcessNode_ResumeAfterFailedCopy(t *testing.T) {
+	defer func(savedAction tAction, savedMode string) { action, mode = savedAction, savedMode }(action, mode)
+	action, mode = appActionUpgrade, "upgrade"
+	softwareupgrade.SetSSHTimeout(5 * time.Second)
+	defer softwareupgrade.ClearSSHConfigCache()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node := startTestNode(t)
+	defer node.close()
+	sourceFilename := filepath.Join(dir, "quorum")
+	session := newTestCanarySession(t, dir, softwareupgrade.UpgradeInfo{StopCmd: "stop-quorum", StartCmd: "start-quorum",
+		Copy: map[string]softwareupgrade.UpgradeStruct{"1": {SourceFilePath: sourceFilename, DestFilePath: "/opt/quorum/quorum",
+			Permissions: "0755", UserGroup: "root:root", BackupStrategy: "copy"}}}, node.address())
+	journalFilename := filepath.Join(dir, "journal.session")
+	session.journal = softwareupgrade.NewJournal(journalFilename, "suffix")
+
+	// the source file is missing, so the copy fails after the backup, and the software is started again
+	if _, err = session.processNode(node.address(), []string{"quorum"}); err == nil {
+		t.Fatal("A failed copy should fail the node")
+	}
+	if session.journal.Stopped(node.address(), "quorum") || !session.journal.Completed(node.address(), "quorum", softwareupgrade.CStepBackedUp) {
+		t.Fatalf("The journal should record that the software is running again after its backup, but the entry is %+v",
+			session.journal.Get(node.address(), "quorum"))
+	}
+
+	if err = ioutil.WriteFile(sourceFilename, []byte("quorum"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	action, mode = appActionResumeUpgrade, "resume-upgrade"
+	session.resumeUpgrade = true
+	if session.journal, err = softwareupgrade.LoadJournal(journalFilename); err != nil {
+		t.Fatal(err)
+	}
+	before := len(node.ran())
+	session.processNode(node.address(), []string{"quorum"})
+	stopIndex, copyIndex := -1, -1
+	for i, command := range node.ran()[before:] {
+		switch {
+		case command == "stop-quorum" && stopIndex < 0:
+			{
+				stopIndex = i
+			}
+		case strings.Contains(command, "scp -t") && copyIndex < 0:
+			{
+				copyIndex = i
+			}
+		}
+	}
+	if stopIndex < 0 || copyIndex < 0 || stopIndex > copyIndex {
+		t.Fatalf("The software should be stopped before the files are copied when resuming, but the commands are %v", node.ran()[before:])
+	}
+}
+
+func TestProcessGroup_CanaryRolledBack(t *testing.T) {
+	defer func(savedAction tAction, savedMode string) { action, mode = savedAction, savedMode }(action, mode)
+	action, mode = appActionUpgrade, "upgrade"
+	softwareupgrade.SetSSHTimeout(5 * time.Second)
+	defer softwareupgrade.ClearSSHConfigCache()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the source file is missing, so the copy fails after the backup, and the backup is restored
+	canary, other := startTestNode(t), startTestNode(t)
+	defer canary.close()
+	defer other.close()
+	session := newTestCanarySession(t, dir, softwareupgrade.UpgradeInfo{StopCmd: "stop", StartCmd: "start", OnFailure: softwareupgrade.COnFailureRollback,
+		Copy: map[string]softwareupgrade.UpgradeStruct{"1": {SourceFilePath: filepath.Join(dir, "missing"), DestFilePath: "/opt/quorum/quorum",
+			Permissions: "0755", UserGroup: "root:root", BackupStrategy: "copy"}}}, canary.address(), other.address())
+	session.journal = softwareupgrade.NewJournal(filepath.Join(dir, "journal.session"), "suffix")
+	session.config.Common.Canary = map[string]softwareupgrade.CanaryInfo{"Quorum-Validators": {Count: 1}}
+
+	groupNodes := session.config.GetGroupNodes("Quorum-Validators")
+	if _, err = session.processGroup("Quorum-Validators", groupNodes, []string{"quorum"}); err == errCanaryFailed {
+		t.Fatal("A canary that's rolled back and started again shouldn't abort the group")
+	}
+	if connections := atomic.LoadInt32(&other.connections); connections == 0 {
+		t.Fatal("The other node should be processed after the canary was rolled back")
+	}
+	if !session.rollbackSession.RolledBackInfo.ExistsNodeSoftware(canary.address(), "quorum") ||
+		session.failedUpgradeInfo.ExistsNodeSoftware(canary.address(), "quorum") {
+		t.Fatal("The canary should be recorded as rolled back rather than failed")
+	}
+	if entry := session.journal.Get(canary.address(), "quorum"); entry.Step != "" || entry.Running {
+		t.Fatalf("The journal entry of the rolled back software should be removed, but it's %+v", entry)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "sync"
+
+// runNodes calls work for each of the given nodes, with at most maxParallel nodes being worked on at the same time.
+// No further nodes are started once termination has been requested, or once work has failed for any node.
+// Returns the number of nodes that were started, and the nodes that work failed for.
+func runNodes(nodes []string, maxParallel int, work func(node string) error) (started int, failedNodes []string) {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+	)
+	stopped := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(failedNodes) > 0 || Terminated()
+	}
+	nodeCh := make(chan string)
+	for i := 0; i < maxParallel && i < len(nodes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for node := range nodeCh {
+				if stopped() {
+					continue
+				}
+				mutex.Lock()
+				started++
+				mutex.Unlock()
+				if err := work(node); err != nil {
+					mutex.Lock()
+					failedNodes = append(failedNodes, node)
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for _, node := range nodes {
+		if stopped() {
+			break
+		}
+		nodeCh <- node
+	}
+	close(nodeCh)
+	wg.Wait() // waits for the nodes already started to complete
+	return
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testNodes(count int) (nodes []string) {
+	for i := 1; i <= count; i++ {
+		nodes = append(nodes, fmt.Sprintf("node%d", i))
+	}
+	return
+}
+
+func TestRunNodes_MaxParallel(t *testing.T) {
+	var running, maxRunning int32
+	work := func(node string) error {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			highest := atomic.LoadInt32(&maxRunning)
+			if current <= highest || atomic.CompareAndSwapInt32(&maxRunning, highest, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+
+	for _, maxParallel := range []int{0, 1, 3} {
+		atomic.StoreInt32(&maxRunning, 0)
+		started, failedNodes := runNodes(testNodes(10), maxParallel, work)
+		if started != 10 || len(failedNodes) != 0 {
+			t.Fatalf("maxParallel %d: expected 10 nodes started without failures, got %d started, failed: %v", maxParallel, started, failedNodes)
+		}
+		expected := int32(maxParallel)
+		if expected < 1 {
+			expected = 1
+		}
+		if maxRunning != expected {
+			t.Fatalf("maxParallel %d: expected at most %d nodes at the same time, got %d", maxParallel, expected, maxRunning)
+		}
+	}
+}
+
+func TestRunNodes_StopAfterFailure(t *testing.T) {
+	var (
+		mutex     sync.Mutex
+		processed []string
+	)
+	work := func(node string) error {
+		mutex.Lock()
+		processed = append(processed, node)
+		mutex.Unlock()
+		if node == "node2" {
+			return errors.New("failed")
+		}
+		return nil
+	}
+	started, failedNodes := runNodes(testNodes(5), 1, work)
+	if started != 2 || len(processed) != 2 {
+		t.Fatalf("No node should be started after node2 failed, started: %d, processed: %v", started, processed)
+	}
+	if len(failedNodes) != 1 || failedNodes[0] != "node2" {
+		t.Fatalf("Expected node2 to be reported as failed, got %v", failedNodes)
+	}
+}
+
+func TestRunNodes_StopAfterTermination(t *testing.T) {
+	defer atomic.StoreInt32(&terminated, 0)
+	var processed int32
+	work := func(node string) error {
+		if atomic.AddInt32(&processed, 1) == 3 {
+			requestTermination()
+		}
+		return nil
+	}
+	started, failedNodes := runNodes(testNodes(10), 2, work)
+	if started > 4 || len(failedNodes) != 0 {
+		t.Fatalf("At most the nodes already handed out should be started after termination, started: %d, failed: %v", started, failedNodes)
+	}
+	if int(processed) != started {
+		t.Fatalf("Every node started should be completed, started: %d, processed: %d", started, processed)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"softwareupgrade"
+	"time"
+)
+
+// pinHostKeys adds the host key of every node in the configuration to its known_hosts file.
+// Returns false if the key of any node couldn't be pinned.
+func pinHostKeys(jsonFilenames []string, knownHosts string) (result bool, err error) {
+	data, err := softwareupgrade.LoadConfigFiles(jsonFilenames)
+	if err != nil {
+		return
+	}
+	var config softwareupgrade.UpgradeConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		return false, fmt.Errorf("Unable to parse the configuration, use Upgrade -mode=validate to find the problems, error: %v", err)
+	}
+	timeout := 5 * time.Second
+	if config.Common.SSHTimeout != "" {
+		if timeout, err = time.ParseDuration(config.Common.SSHTimeout); err != nil {
+			return
+		}
+	}
+	softwareupgrade.SetSSHTimeout(timeout)
+
+	result = true
+	for _, pin := range config.PinHostKeys(knownHosts) {
+		if pin.Err != nil {
+			fmt.Printf("%s: %v\n", pin.Node, pin.Err)
+			result = false
+			continue
+		}
+		fmt.Printf("%s: %s %s in %s\n", pin.Node, pin.Fingerprint, pin.Status, pin.KnownHosts)
+	}
+	return
+}
+
+func main() {
+	var (
+		jsonFilenames softwareupgrade.StringList
+		knownHosts    string
+	)
+	flag.Var(&jsonFilenames, "json", "Specifies the configuration file to read the nodes from, in JSON, YAML or TOML format according to its extension. Specify it more than once to merge several files")
+	flag.StringVar(&knownHosts, "known-hosts", "", "Specifies the known_hosts file to pin the host keys in, instead of the ssh_known_hosts of each node (default: ~/.ssh/known_hosts)")
+	flag.Parse()
+
+	if len(jsonFilenames) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	pinned, err := pinHostKeys(jsonFilenames, knownHosts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if !pinned {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,188 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IsDirectory returns true if the remote destination is a directory, rather than a file
+func (upgradeStruct *UpgradeStruct) IsDirectory() bool {
+	return upgradeStruct.Type == CCopyTypeArchive || upgradeStruct.Type == CCopyTypeDirectory
+}
+
+// DeletesExtraneous returns true if the remote files that don't exist in the local directory are deleted
+func (upgradeStruct UpgradeStruct) DeletesExtraneous() bool {
+	return upgradeStruct.Delete != nil && *upgradeStruct.Delete
+}
+
+// backupCommand returns the command that backs up the remote destination with the given suffix,
+// or an empty string if there's no backup strategy.
+func (upgradeStruct *UpgradeStruct) backupCommand(suffix string) (cmd string) {
+	backupName := upgradeStruct.DestFilePath + suffix
+	switch upgradeStruct.BackupStrategy {
+	case "copy":
+		{
+			if upgradeStruct.IsDirectory() {
+				cmd = fmt.Sprintf("sudo cp -a %s %s", upgradeStruct.DestFilePath, backupName)
+			} else {
+				cmd = fmt.Sprintf("sudo cp %s %s", upgradeStruct.DestFilePath, backupName)
+			}
+		}
+	case "move":
+		{
+			cmd = fmt.Sprintf("sudo mv %s %s", upgradeStruct.DestFilePath, backupName)
+		}
+	}
+	return
+}
+
+// restoreBackupCommand returns the command that replaces the remote destination with the backup with the given suffix
+func (upgradeStruct *UpgradeStruct) restoreBackupCommand(suffix string) string {
+	backupName := upgradeStruct.DestFilePath + suffix
+	if upgradeStruct.IsDirectory() {
+		return fmt.Sprintf("sudo rm -rf %s && sudo mv %s %s", upgradeStruct.DestFilePath, backupName, upgradeStruct.DestFilePath)
+	}
+	return fmt.Sprintf("sudo mv %s %s", backupName, upgradeStruct.DestFilePath)
+}
+
+// deleteBackupCommand returns the command that deletes the backup with the given suffix
+func (upgradeStruct *UpgradeStruct) deleteBackupCommand(suffix string) string {
+	backupName := upgradeStruct.DestFilePath + suffix
+	if upgradeStruct.IsDirectory() {
+		return fmt.Sprintf("sudo rm -rf %s", backupName)
+	}
+	return fmt.Sprintf("sudo rm %s", backupName)
+}
+
+// copyDirectory copies the source to the remote destination directory according to the type of the UpgradeStruct
+func (upgradeStruct *UpgradeStruct) copyDirectory(sshConfig *SSHConfig) (err error) {
+	if upgradeStruct.Type == CCopyTypeDirectory {
+		if err = sshConfig.CopyDirectory(upgradeStruct.SourceFilePath, upgradeStruct.DestFilePath); err == nil && upgradeStruct.DeletesExtraneous() {
+			err = sshConfig.deleteExtraneous(upgradeStruct.SourceFilePath, upgradeStruct.DestFilePath)
+		}
+		return
+	}
+	var expectedSha256 string
+	if upgradeStruct.VerifyCopy != "" {
+		if expectedSha256, err = NewLocalHostHasher().Sha256sum(upgradeStruct.SourceFilePath); err != nil {
+			return
+		}
+	}
+	return sshConfig.ExtractArchive(upgradeStruct.SourceFilePath, upgradeStruct.DestFilePath, expectedSha256)
+}
+
+// ExtractArchive uploads the local archive, eg, a tar.gz file, to the host specified in the given SSHConfig,
+// and extracts it into the remote directory, which is created if it doesn't exist.
+// If expectedSha256 is specified, the archive is only extracted if the uploaded archive has the same hash.
+func (sshConfig *SSHConfig) ExtractArchive(localArchive, remoteDir, expectedSha256 string) (err error) {
+	remoteArchive := fmt.Sprintf("/tmp/%s%s", path.Base(localArchive), backupSuffix)
+	if err = sshConfig.CopyLocalFileToRemoteFile(localArchive, remoteArchive, "0644"); err != nil {
+		return
+	}
+	defer sshConfig.Run(fmt.Sprintf("sudo rm -f %s", remoteArchive))
+
+	if expectedSha256 != "" {
+		var remoteSha256 string
+		if remoteSha256, err = sshConfig.Sha256sum(remoteArchive); err != nil {
+			return
+		}
+		if remoteSha256 != expectedSha256 {
+			return fmt.Errorf("uploaded archive %s has sha256 %s, expected %s", remoteArchive, remoteSha256, expectedSha256)
+		}
+	}
+
+	cmd := fmt.Sprintf("sudo mkdir -p %s && sudo tar -xf %s -C %s", remoteDir, remoteArchive, remoteDir)
+	if output, runErr := sshConfig.Run(cmd); runErr != nil {
+		err = fmt.Errorf("unable to extract %s into %s: %v %s", remoteArchive, remoteDir, runErr, output)
+	}
+	return
+}
+
+// changeDirectoryOwnership changes the owner, and the permissions if specified, of the directory and everything in it
+func (sshConfig *SSHConfig) changeDirectoryOwnership(dir, owner, permissions string) (err error) {
+	if owner != "" {
+		if _, err = sshConfig.Run(fmt.Sprintf("sudo chown -R %s %s", owner, dir)); err != nil {
+			return
+		}
+	}
+	if permissions != "" {
+		_, err = sshConfig.Run(fmt.Sprintf("sudo chmod -R %s %s", permissions, dir))
+	}
+	return
+}
+
+// deleteExtraneous deletes everything in the remote directory that doesn't exist in the local directory
+func (sshConfig *SSHConfig) deleteExtraneous(localDir, remoteDir string) (err error) {
+	localEntries, err := listLocalEntries(localDir)
+	if err != nil {
+		return
+	}
+	remoteDir = path.Clean(remoteDir)
+	remoteListing, err := sshConfig.Run(fmt.Sprintf("sudo find %s -mindepth 1", shellQuote(remoteDir)))
+	if err != nil {
+		return
+	}
+	extraneous := extraneousPaths(remoteDir, remoteListing, localEntries)
+	if len(extraneous) == 0 {
+		return
+	}
+	for i := range extraneous {
+		DebugLog.Println("Deleting %s as it doesn't exist in %s", extraneous[i], localDir)
+		extraneous[i] = shellQuote(extraneous[i])
+	}
+	_, err = sshConfig.Run("sudo rm -rf " + strings.Join(extraneous, " "))
+	return
+}
+
+// listLocalEntries returns the paths, relative to the local directory, of everything in it
+func listLocalEntries(localDir string) (result map[string]bool, err error) {
+	if localDir, err = Expand(localDir); err != nil {
+		return
+	}
+	result = make(map[string]bool)
+	err = filepath.Walk(localDir, func(entryPath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if relativePath, err := filepath.Rel(localDir, entryPath); err == nil && relativePath != "." {
+			result[filepath.ToSlash(relativePath)] = true
+		}
+		return nil
+	})
+	return
+}
+
+// extraneousPaths returns the remote paths, listed one per line, that don't exist in the local entries.
+// Paths inside a directory that is extraneous are not returned, as they are deleted with the directory.
+func extraneousPaths(remoteDir, remoteListing string, localEntries map[string]bool) (result []string) {
+	prefix := remoteDir + "/"
+	for _, line := range strings.Split(remoteListing, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasPrefix(line, prefix) {
+			continue
+		}
+		relativePath := strings.TrimPrefix(line, prefix)
+		if localEntries[relativePath] {
+			continue
+		}
+		insideExtraneous := false
+		for _, extraneous := range result {
+			if strings.HasPrefix(line, extraneous+"/") {
+				insideExtraneous = true
+				break
+			}
+		}
+		if !insideExtraneous {
+			result = append(result, line)
+		}
+	}
+	return
+}
+
+// shellQuote quotes the string so that it's passed as a single argument by the shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
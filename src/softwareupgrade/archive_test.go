@@ -0,0 +1,69 @@
+package softwareupgrade
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpgradeStruct_BackupCommands(t *testing.T) {
+	file := UpgradeStruct{DestFilePath: "/usr/local/bin/geth", BackupStrategy: "copy"}
+	archive := UpgradeStruct{DestFilePath: "/opt/quorum", BackupStrategy: "copy", Type: CCopyTypeArchive}
+	tests := []struct {
+		cmd      string
+		expected string
+	}{
+		{file.backupCommand(".bak"), "sudo cp /usr/local/bin/geth /usr/local/bin/geth.bak"},
+		{archive.backupCommand(".bak"), "sudo cp -a /opt/quorum /opt/quorum.bak"},
+		{file.restoreBackupCommand(".bak"), "sudo mv /usr/local/bin/geth.bak /usr/local/bin/geth"},
+		{archive.restoreBackupCommand(".bak"), "sudo rm -rf /opt/quorum && sudo mv /opt/quorum.bak /opt/quorum"},
+		{file.deleteBackupCommand(".bak"), "sudo rm /usr/local/bin/geth.bak"},
+		{archive.deleteBackupCommand(".bak"), "sudo rm -rf /opt/quorum.bak"},
+	}
+	for _, test := range tests {
+		if test.cmd != test.expected {
+			t.Fatalf("Command should be %q, but is %q", test.expected, test.cmd)
+		}
+	}
+
+	archive.BackupStrategy = "move"
+	if cmd := archive.backupCommand(".bak"); cmd != "sudo mv /opt/quorum /opt/quorum.bak" {
+		t.Fatalf("Move should move the whole directory, but is %q", cmd)
+	}
+	archive.BackupStrategy = ""
+	if cmd := archive.backupCommand(".bak"); cmd != "" {
+		t.Fatalf("No backup strategy should have no backup command, but is %q", cmd)
+	}
+}
+
+func TestExtraneousPaths(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	os.MkdirAll(filepath.Join(tempDir, "lib"), 0755)
+	ioutil.WriteFile(filepath.Join(tempDir, "geth"), nil, 0755)
+	ioutil.WriteFile(filepath.Join(tempDir, "lib", "a.so"), nil, 0644)
+
+	localEntries, err := listLocalEntries(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(localEntries, map[string]bool{"geth": true, "lib": true, "lib/a.so": true}) {
+		t.Fatalf("Unexpected local entries: %v", localEntries)
+	}
+
+	remoteListing := "/opt/quorum/bin/geth\n/opt/quorum/bin/bootnode\n/opt/quorum/bin/lib\n/opt/quorum/bin/lib/a.so\n" +
+		"/opt/quorum/bin/lib/b.so\n/opt/quorum/bin/old\n/opt/quorum/bin/old/c.so\n"
+	extraneous := extraneousPaths("/opt/quorum/bin", remoteListing, localEntries)
+	expected := []string{"/opt/quorum/bin/bootnode", "/opt/quorum/bin/lib/b.so", "/opt/quorum/bin/old"}
+	if !reflect.DeepEqual(extraneous, expected) {
+		t.Fatalf("Extraneous paths should be %v, but are %v", expected, extraneous)
+	}
+	if quoted := shellQuote("it's"); quoted != `'it'\''s'` {
+		t.Fatalf("Unexpected quoting: %s", quoted)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
 )
 
@@ -16,9 +18,24 @@ type (
 
 	// SSHInfo contains the SSH cert and the username to be used for a SSH connection
 	SSHInfo struct {
-		SSHCert     string `json:"ssh_cert"`
-		SSHUserName string `json:"ssh_username"`
-		SSHTimeout  string `json:"ssh_timeout"`
+		SSHCert          string     `json:"ssh_cert"`
+		SSHUserName      string     `json:"ssh_username"`
+		SSHTimeout       string     `json:"ssh_timeout"`
+		SSHPassphrase    string     `json:"ssh_passphrase"`      // where the passphrase of encrypted keys is read from: prompt, env:NAME or file:FILENAME
+		SSHAuth          []string   `json:"ssh_auth"`            // the authentication methods tried in order: agent, key (ssh_cert) or key:FILENAME
+		SSHKnownHosts    string     `json:"ssh_known_hosts"`     // the known_hosts file the host keys are checked against
+		SSHHostKeyPolicy string     `json:"ssh_host_key_policy"` // strict (the default), accept-new or off
+		SSHJumpHosts     []JumpHost `json:"ssh_jump_hosts"`      // the bastions the connection is tunneled through, in order
+	}
+
+	// JumpHost specifies a bastion that connections to nodes are tunneled through.
+	// The settings that aren't specified are those of the node.
+	JumpHost struct {
+		Host          string   `json:"host"` // the address of the jump host, with an optional port
+		SSHCert       string   `json:"ssh_cert"`
+		SSHUserName   string   `json:"ssh_username"`
+		SSHPassphrase string   `json:"ssh_passphrase"`
+		SSHAuth       []string `json:"ssh_auth"`
 	}
 
 	// RollbackStruct contains the necessary information in order to rollback a particular
@@ -29,21 +46,37 @@ type (
 
 	// RollbackSession contains the information required to rollback an upgrade/add session
 	RollbackSession struct {
-		SessionSuffix string             `json:"SessionSuffix"`
-		RollbackInfo  *FailedUpgradeInfo `json:"RollbackInfo"`
-		Mode          string             `json:"Mode"`
+		SessionSuffix  string             `json:"SessionSuffix"`
+		RollbackInfo   *FailedUpgradeInfo `json:"RollbackInfo"`
+		Mode           string             `json:"Mode"`
+		RolledBackInfo *FailedUpgradeInfo `json:"RolledBackInfo"`      // the software that was rolled back automatically when its upgrade failed
+		Versions       *VersionInfo       `json:"Versions"`            // the versions of the software before and after the upgrade
+		Decisions      []OperatorDecision `json:"Decisions,omitempty"` // the choices made by the operator in interactive mode
+	}
+
+	// OperatorDecision records the choice made by the operator before a node or a software group was processed
+	OperatorDecision struct {
+		Time   time.Time `json:"Time"`
+		Mode   string    `json:"Mode"`
+		Scope  string    `json:"Scope"` // either node or group
+		Name   string    `json:"Name"`  // the name of the node or software group
+		Choice string    `json:"Choice"`
 	}
 
 	// UpgradeStruct contains the information necessary to add/upgrade a particular software
 	// on a node
 	UpgradeStruct struct {
-		SourceFilePath string `json:"Local_Filename"`  // local file path
-		DestFilePath   string `json:"Remote_Filename"` // remote file path
-		UserGroup      string `json:"UserGroup"`       // specifies user:group ownership
-		Permissions    string `json:"Permissions"`     // permissions of the newly copied file
-		VerifyCopy     string `json:"VerifyCopy"`      // command to run to verify copy is successful
-		RollbackPath   string `json:"RollbackPath"`    // internal rollback
-		BackupStrategy string `json:"BackupStrategy"`  // either copy or move
+		SourceFilePath string `json:"Local_Filename"`     // local file path
+		DestFilePath   string `json:"Remote_Filename"`    // remote file path
+		UserGroup      string `json:"UserGroup"`          // specifies user:group ownership
+		Permissions    string `json:"Permissions"`        // permissions of the newly copied file
+		VerifyCopy     string `json:"VerifyCopy"`         // command to run to verify copy is successful
+		RollbackPath   string `json:"RollbackPath"`       // internal rollback
+		BackupStrategy string `json:"BackupStrategy"`     // either copy or move
+		Sha256         string `json:"Sha256"`             // the sha256 hash of the file, required if Local_Filename is a URL
+		Type           string `json:"Type"`               // either empty for a file, archive to extract the file into the Remote_Filename directory, or directory
+		Delete         *bool  `json:"Delete,omitempty"`   // for the directory type, deletes the remote files that don't exist in the local directory
+		Template       *bool  `json:"Template,omitempty"` // renders the local file as a text/template for each node before copying it
 	}
 
 	// UpgradeInfo contains the information necessary to start and stop a particular software on a node
@@ -57,17 +90,34 @@ type (
 		// copy will be numeric order.
 		Copy map[string]UpgradeStruct `json:"Copy"`
 		Exec []string                 `json:"Exec"`
+
+		// Health checks are run in order after the software is started,
+		// the next node is only processed after all of them pass.
+		HealthCheck []HealthCheck `json:"health_check"`
+
+		// Specifies what to do when the upgrade fails, either empty, or rollback
+		OnFailure string `json:"on_failure"`
+
+		// The command that prints the version of the software, the regex extracts the version from its output,
+		// and the target version is the version the software must report after the upgrade.
+		VersionCmd    string `json:"version_cmd"`
+		VersionRegex  string `json:"version_regex"`
+		TargetVersion string `json:"target_version"`
 	}
 
 	// FailedUpgradeInfo records the name of nodes together with the software it failed to upgrade.
+	// It is safe to be updated by multiple goroutines.
 	FailedUpgradeInfo struct {
 		FailedNodeSoftware map[string][]string `json:"NodeSoftware"`
+		mutex              sync.Mutex
 	}
 
 	// NodeInfoContainer contains the information necessary to connect to a particular node and its upgrade information
 	NodeInfoContainer struct {
 		UpgradeInfo
 		SSHInfo
+		Vars         map[string]string `json:"vars"` // custom variables of the node, available to templates
+		TemplateData TemplateData      `json:"-"`    // the variables available to templates, set by GetNodeUpgradeInfo
 	}
 
 	// NodeUpgradeConfig specifies the upgrade configuration for each node,
@@ -76,6 +126,13 @@ type (
 		NodeUpgradeInfo map[string]UpgradeInfo
 	}
 
+	// CanaryInfo specifies the nodes of a software group that are upgraded before the rest of the group
+	CanaryInfo struct {
+		Nodes []string `json:"nodes"` // names of the canary nodes
+		Count int      `json:"count"` // if nodes is not specified, the first count nodes of the group are the canary nodes
+		Soak  Duration `json:"soak"`  // the amount of time the canary nodes must stay healthy before the rest of the group is upgraded
+	}
+
 	// Duration contains the delay to sleep between upgrades
 	Duration struct {
 		time.Duration
@@ -84,9 +141,14 @@ type (
 	// UpgradeConfig contains the configuration for upgrading nodes
 	UpgradeConfig struct {
 		Common struct {
-			SSHInfo                           // This specifies the general and common SSL configuration for common nodes
-			SoftwareGroup map[string][]string `json:"software_group"` // This specifies the software type that's possible to run on a node, the start and stop command, the command used to upgrade the software
-			GroupPause    Duration            `json:"group_pause_after_upgrade"`
+			SSHInfo                                // This specifies the general and common SSL configuration for common nodes
+			SoftwareGroup map[string][]string      `json:"software_group"` // This specifies the software type that's possible to run on a node, the start and stop command, the command used to upgrade the software
+			GroupPause    Duration                 `json:"group_pause_after_upgrade"`
+			MaxParallel   map[string]int           `json:"max_parallel"` // This specifies the number of nodes in each software group that can be processed at the same time
+			Canary        map[string]CanaryInfo    `json:"canary"`       // This specifies the canary nodes of each software group
+			GroupOrder    []string                 `json:"group_order"`  // This specifies the order in which software groups are processed
+			DependsOn     map[string][]string      `json:"depends_on"`   // This specifies the software groups that must be processed before each software group
+			Consensus     map[string]ConsensusInfo `json:"consensus"`    // This specifies the number of nodes in each software group that can be stopped at the same time
 		} `json:"common"`
 		Nodes    map[string]NodeInfoContainer `json:"nodes"`    // This is a map with the key as the DNS hostnames of each node that participates in the network
 		Software map[string]UpgradeInfo       `json:"software"` // this defines each individual piece of software
@@ -97,6 +159,10 @@ type (
 		// node2 and node4 runs group 2.
 		// node5, node6, node7 runs group 3.
 		SoftwareGroupNodes map[string][]string `json:"groupnodes"`
+		// This overrides the software definitions for the nodes of a software group, eg,
+		// a different stop command for all the validators. Node overrides take precedence.
+		GroupOverrides map[string]map[string]UpgradeInfo `json:"group_overrides"` // group -> software -> overrides
+		artifacts      map[string]string                 // the cached path of each URL, by URL and sha256, see ResolveArtifacts
 	}
 )
 
@@ -141,9 +207,17 @@ func (nodeInfo *NodeInfoContainer) RunAdd(sshConfig *SSHConfig) (err error) {
 			if (UpgradeStruct{}) == upgradeStruct { // skip empty struct, or empty source
 				continue
 			}
-			err = sshConfig.CopyLocalFileToRemoteFile(
-				upgradeStruct.SourceFilePath,
-				upgradeStruct.DestFilePath, upgradeStruct.Permissions)
+			if upgradeStruct.IsDirectory() {
+				err = upgradeStruct.copyDirectory(sshConfig)
+				if err == nil {
+					err = sshConfig.changeDirectoryOwnership(upgradeStruct.DestFilePath, upgradeStruct.UserGroup, upgradeStruct.Permissions)
+				}
+				if err != nil {
+					msg = fmt.Sprintf("%s\n%v", msg, err)
+				}
+				continue
+			}
+			err = nodeInfo.copyFile(sshConfig, upgradeStruct)
 			if err != nil {
 				if msg == "" {
 					msg = fmt.Sprintf("%v", err)
@@ -192,9 +266,7 @@ func (nodeInfo *NodeInfoContainer) RunDeleteRollback(sshConfig *SSHConfig, rollb
 					}
 				}
 			}
-			rollbackName := upgradeStruct.DestFilePath + rollbackSuffix
-			cmd := fmt.Sprintf("sudo rm %s", rollbackName)
-			_, err = sshConfig.Run(cmd)
+			_, err = sshConfig.Run(upgradeStruct.deleteBackupCommand(rollbackSuffix))
 		}
 	}
 	if msg != "" {
@@ -229,11 +301,10 @@ func (nodeInfo *NodeInfoContainer) RunRollback(sshConfig *SSHConfig, rollbackSuf
 					DebugLog.Println(msg)
 				}
 			}
-			rollbackName := upgradeStruct.DestFilePath + rollbackSuffix
-			cmd := fmt.Sprintf("sudo mv %s %s", rollbackName, upgradeStruct.DestFilePath)
-			_, err = sshConfig.Run(cmd)
+			_, err = sshConfig.Run(upgradeStruct.restoreBackupCommand(rollbackSuffix))
 			if err == nil {
-				if upgradeStruct.UserGroup != "" {
+				// a directory's backup keeps the ownership of everything in it
+				if upgradeStruct.UserGroup != "" && !upgradeStruct.IsDirectory() {
 					// if fileOwner has been retrieved, change the file ownership to the previous
 					err = sshConfig.changeFileOwnership(upgradeStruct.DestFilePath, upgradeStruct.UserGroup)
 					if err != nil {
@@ -260,32 +331,36 @@ func (nodeInfo *NodeInfoContainer) RunRollback(sshConfig *SSHConfig, rollbackSuf
 
 // RunUpgrade runs the upgrade for a particular node
 func (nodeInfo *NodeInfoContainer) RunUpgrade(sshConfig *SSHConfig) (err error) {
-	// Support i := 0 or i := 1 by checking for empty struct
+	return nodeInfo.RunJournaledUpgrade(sshConfig, nil, "", "")
+}
+
+// RunJournaledUpgrade runs the upgrade for a particular node's software, recording each step in the journal as it's completed.
+// Steps that the journal has recorded as completed are skipped, so that an interrupted upgrade continues from the exact step.
+// journal may be nil, in which case every step is run and nothing is recorded.
+// Nothing is copied unless every file has been backed up, and a step that can't be recorded in the journal stops the upgrade,
+// as resuming it wouldn't be safe.
+func (nodeInfo *NodeInfoContainer) RunJournaledUpgrade(sshConfig *SSHConfig, journal *Journal, node, software string) (err error) {
 	var msg string
-	if len(nodeInfo.Copy) > 0 {
-		for i := 0; i < len(nodeInfo.Copy)+1; i++ {
-			index := IntToStr(i)
+	record := func(step string) error {
+		if err := journal.Record(node, software, step); err != nil {
+			return fmt.Errorf("Unable to record step %s in the journal, error: %v", step, err)
+		}
+		return nil
+	}
+	if entry := journal.Get(node, software); len(entry.Copy) > 0 {
+		// restore the permissions and owner of the files from before the upgrade was interrupted
+		nodeInfo.Copy = entry.Copy
+	}
+	indexes := nodeInfo.copyIndexes()
+	// software without files to copy, eg, software that only has Exec commands, only runs the post-commands step
+	hasFiles := len(indexes) > 0
+
+	if hasFiles && !journal.Completed(node, software, CStepBackedUp) {
+		backupMsg := ""
+		for _, index := range indexes {
 			upgradeStruct := nodeInfo.Copy[index]
-			if (UpgradeStruct{}) == upgradeStruct || upgradeStruct.SourceFilePath == "" { // skip empty struct, or empty source
-				continue
-			}
-			var (
-				sourceHash, destHash string
-			)
-			if upgradeStruct.VerifyCopy != "" {
-				localHasher := NewLocalHostHasher()
-				switch upgradeStruct.VerifyCopy {
-				case "md5":
-					{
-						sourceHash, err = localHasher.Md5sum(upgradeStruct.SourceFilePath)
-					}
-				case "sha256":
-					{
-						sourceHash, err = localHasher.Sha256sum(upgradeStruct.SourceFilePath)
-					}
-				}
-			}
-			if upgradeStruct.Permissions == "" {
+			// the permissions of a directory are only applied to its contents when specified
+			if upgradeStruct.Permissions == "" && !upgradeStruct.IsDirectory() {
 				upgradeStruct.Permissions, err = sshConfig.getFilePermissions(upgradeStruct.DestFilePath)
 			}
 			if upgradeStruct.UserGroup == "" {
@@ -293,104 +368,237 @@ func (nodeInfo *NodeInfoContainer) RunUpgrade(sshConfig *SSHConfig) (err error)
 					DebugLog.Printf("Unable to get owner for %s, error: %v\n", upgradeStruct.DestFilePath, err)
 				}
 			}
-			if upgradeStruct.BackupStrategy != "" {
-				var cmd, backupName string
-				backupName = upgradeStruct.DestFilePath + backupSuffix
-				switch upgradeStruct.BackupStrategy {
-				case "copy":
-					{
-						cmd = fmt.Sprintf("sudo cp %s %s", upgradeStruct.DestFilePath, backupName)
-					}
-				case "move":
-					{
-						cmd = fmt.Sprintf("sudo mv %s %s", upgradeStruct.DestFilePath, backupName)
-					}
-				}
+			// remember the permissions and owner of the previous file, so that a rollback can restore them
+			nodeInfo.Copy[index] = upgradeStruct
+			if cmd := upgradeStruct.backupCommand(backupSuffix); cmd != "" {
 				backupResult, err := sshConfig.Run(cmd)
 				if err != nil {
-					msg = fmt.Sprintf("%sFailed to implement backup strategy for node: %v software: %s\n", msg, err, backupResult)
+					backupMsg = fmt.Sprintf("%sFailed to implement backup strategy for node: %v software: %s\n", backupMsg, err, backupResult)
 				}
 			}
-			PreUpgradeCmds := nodeInfo.PreUpgrade
-			if len(PreUpgradeCmds) > 0 {
-				DebugLog.Println("Running Pre-Upgrade commands...")
-				for i := range PreUpgradeCmds {
-					cmd := PreUpgradeCmds[i]
-					msg := fmt.Sprintf(`Pre-Upgrade command %d: "%s"`, i, cmd)
-					DebugLog.Println(msg)
-					cmdOutput, err := sshConfig.Run(cmd)
-					msg = fmt.Sprintf(`%d output: "%s", error: "%v"`, i, cmdOutput, err)
-					DebugLog.Println(msg)
-				}
+		}
+		if backupMsg != "" {
+			return errors.New(backupMsg)
+		}
+		if err = journal.RecordCopy(node, software, nodeInfo.Copy); err != nil {
+			return fmt.Errorf("Unable to record the files in the journal, error: %v", err)
+		}
+		if err = record(CStepBackedUp); err != nil {
+			return
+		}
+	}
+
+	copied := !hasFiles || journal.Completed(node, software, CStepCopied)
+	if !copied {
+		runCommands(sshConfig, "Pre-Upgrade", nodeInfo.PreUpgrade)
+		copyMsg := ""
+		for _, index := range indexes {
+			upgradeStruct := nodeInfo.Copy[index]
+			if upgradeStruct.IsDirectory() {
+				err = upgradeStruct.copyDirectory(sshConfig)
+			} else {
+				err = nodeInfo.copyFile(sshConfig, upgradeStruct)
 			}
-			err = sshConfig.CopyLocalFileToRemoteFile(
-				upgradeStruct.SourceFilePath,
-				upgradeStruct.DestFilePath, upgradeStruct.Permissions)
 			if err != nil {
-				msg = fmt.Sprintf("%sError encountered during file transfer in RunUpgrade: %v\n", msg, err)
-			} else if upgradeStruct.VerifyCopy != "" {
+				copyMsg = fmt.Sprintf("%sError encountered during file transfer in RunUpgrade: %v\n", copyMsg, err)
+			}
+		}
+		msg += copyMsg
+		if copied = copyMsg == ""; copied {
+			if err = record(CStepCopied); err != nil {
+				return
+			}
+		}
+	}
+
+	if hasFiles && copied && !journal.Completed(node, software, CStepVerified) {
+		verifyMsg := ""
+		for _, index := range indexes {
+			upgradeStruct := nodeInfo.Copy[index]
+			if upgradeStruct.IsDirectory() {
+				// the contents of a directory aren't hashed, the copy fails if scp couldn't write any of them
+				if err = sshConfig.changeDirectoryOwnership(upgradeStruct.DestFilePath, upgradeStruct.UserGroup, upgradeStruct.Permissions); err != nil {
+					verifyMsg = fmt.Sprintf("%sUnable to set owner for %s, error: %v\n", verifyMsg, upgradeStruct.DestFilePath, err)
+				}
+				continue
+			}
+			if upgradeStruct.VerifyCopy != "" {
+				var sourceHash, destHash string
+				localHasher := nodeInfo.sourceHasher(upgradeStruct, NewLocalHostHasher())
 				switch upgradeStruct.VerifyCopy {
 				case "md5":
 					{
+						sourceHash, err = localHasher.Md5sum(upgradeStruct.SourceFilePath)
 						destHash, err = sshConfig.Md5sum(upgradeStruct.DestFilePath)
 					}
 				case "sha256":
 					{
+						sourceHash, err = localHasher.Sha256sum(upgradeStruct.SourceFilePath)
 						destHash, err = sshConfig.Sha256sum(upgradeStruct.DestFilePath)
 					}
 				}
 				// file transfer successful since the hash is the same
-				if destHash != "" && sourceHash != "" && sourceHash == destHash {
-					if upgradeStruct.UserGroup != "" {
-						// if fileOwner has been retrieved, change the file ownership to the previous
-						err = sshConfig.changeFileOwnership(upgradeStruct.DestFilePath, upgradeStruct.UserGroup)
-					}
-					if err == nil {
-						DebugLog.Println("Upgrade successful!")
-					}
-				} else {
-					msg = fmt.Sprintf("%sUpgrade failed for %s\n", msg, upgradeStruct.DestFilePath)
+				if destHash == "" || sourceHash == "" || sourceHash != destHash {
+					verifyMsg = fmt.Sprintf("%sUpgrade failed for %s\n", verifyMsg, upgradeStruct.DestFilePath)
+					continue
 				}
 			}
-			PostUpgradeCmds := nodeInfo.PostUpgrade
-			if len(PostUpgradeCmds) > 0 {
-				DebugLog.Println("Running Post-Upgrade commands...")
-				for i := range PostUpgradeCmds {
-					cmd := PostUpgradeCmds[i]
-					msg := fmt.Sprintf(`Post-Upgrade command %d: "%s"`, i, cmd)
-					DebugLog.Println(msg)
-					cmdOutput, err := sshConfig.Run(cmd)
-					msg = fmt.Sprintf(`%d output: "%s", error: "%v"`, i, cmdOutput, err)
-					DebugLog.Println(msg)
+			if upgradeStruct.UserGroup != "" {
+				// if fileOwner has been retrieved, change the file ownership to the previous
+				if err = sshConfig.changeFileOwnership(upgradeStruct.DestFilePath, upgradeStruct.UserGroup); err != nil {
+					verifyMsg = fmt.Sprintf("%sUnable to set owner for %s, error: %v\n", verifyMsg, upgradeStruct.DestFilePath, err)
 				}
 			}
 		}
-		if msg != "" {
-			err = errors.New(msg)
+		msg += verifyMsg
+		if verifyMsg == "" {
+			DebugLog.Println("Upgrade successful!")
+			err = record(CStepVerified)
+		} else {
+			// the files are copied again when the upgrade is resumed
+			err = record(CStepBackedUp)
+		}
+		if err != nil {
+			return
 		}
 	}
-	if err == nil && len(nodeInfo.Exec) > 0 {
-		for index := range nodeInfo.Exec {
-			cmd := nodeInfo.Exec[index]
-			cmdResult, err := sshConfig.Run(cmd)
-			if err == nil {
-				DebugLog.Printf(`Exec: "%s", Result: "%s", \n`, cmd, cmdResult)
-			} else {
-				DebugLog.Printf(`Exec: "%s", Result: "%v"`, cmd, err)
+
+	if !journal.Completed(node, software, CStepPostCommands) {
+		runCommands(sshConfig, "Post-Upgrade", nodeInfo.PostUpgrade)
+		if msg == "" {
+			for index := range nodeInfo.Exec {
+				cmd := nodeInfo.Exec[index]
+				cmdResult, err := sshConfig.Run(cmd)
+				if err == nil {
+					DebugLog.Printf(`Exec: "%s", Result: "%s", \n`, cmd, cmdResult)
+				} else {
+					DebugLog.Printf(`Exec: "%s", Result: "%v"`, cmd, err)
+				}
+			}
+			if err = record(CStepPostCommands); err != nil {
+				return
 			}
 		}
 	}
+	if msg != "" {
+		err = errors.New(msg)
+	} else {
+		err = nil
+	}
+	return
+}
+
+// IsUnchanged returns true if every file to copy already exists on the node with the same sha256 hash as the local file,
+// in which case there's nothing to upgrade. Returns false if there are no files to copy.
+func (nodeInfo *NodeInfoContainer) IsUnchanged(remote, local Hasher) (unchanged bool, err error) {
+	indexes := nodeInfo.copyIndexes()
+	if len(indexes) == 0 {
+		return
+	}
+	for _, index := range indexes {
+		upgradeStruct := nodeInfo.Copy[index]
+		if upgradeStruct.IsDirectory() {
+			return
+		}
+		var localHash, remoteHash string
+		if localHash, err = nodeInfo.sourceHasher(upgradeStruct, local).Sha256sum(upgradeStruct.SourceFilePath); err != nil {
+			return
+		}
+		if remoteHash, err = remote.Sha256sum(upgradeStruct.DestFilePath); err != nil {
+			return
+		}
+		if localHash == "" || localHash != remoteHash {
+			return
+		}
+	}
+	unchanged = true
+	return
+}
+
+// copyIndexes returns the indexes of the files to copy in numeric order, skipping empty structs, or empty sources.
+// Supports indexes starting from 0 or 1.
+func (nodeInfo *NodeInfoContainer) copyIndexes() (result []string) {
+	for i := 0; i < len(nodeInfo.Copy)+1; i++ {
+		index := IntToStr(i)
+		upgradeStruct := nodeInfo.Copy[index]
+		if (UpgradeStruct{}) == upgradeStruct || upgradeStruct.SourceFilePath == "" {
+			continue
+		}
+		result = append(result, index)
+	}
 	return
 }
 
-// GetGroupNames gets the groups specified in the config
+// runCommands runs the given commands on the node, logging their output.
+func runCommands(sshConfig *SSHConfig, title string, cmds []string) {
+	if len(cmds) > 0 {
+		DebugLog.Println("Running %s commands...", title)
+		for i := range cmds {
+			cmd := cmds[i]
+			msg := fmt.Sprintf(`%s command %d: "%s"`, title, i, cmd)
+			DebugLog.Println(msg)
+			cmdOutput, err := sshConfig.Run(cmd)
+			msg = fmt.Sprintf(`%d output: "%s", error: "%v"`, i, cmdOutput, err)
+			DebugLog.Println(msg)
+		}
+	}
+}
+
+// GetGroupNames gets the groups specified in the config, sorted by name
 func (config *UpgradeConfig) GetGroupNames() (result []string) {
 	for groupKey := range config.SoftwareGroupNodes {
 		result = append(result, groupKey)
 	}
+	sort.Strings(result)
 	return
 }
 
+// GetGroupCanaryNodes splits the nodes belonging to the specified group into the canary nodes and the remaining nodes.
+// canaryNodes is empty if the group doesn't have any canary nodes.
+func (config *UpgradeConfig) GetGroupCanaryNodes(groupName string) (canaryNodes, otherNodes []string) {
+	groupNodes := config.GetGroupNodes(groupName)
+	canary := config.Common.Canary[groupName]
+	isCanary := make(map[string]bool)
+	if len(canary.Nodes) > 0 {
+		for _, node := range canary.Nodes {
+			isCanary[node] = true
+		}
+	} else {
+		for i := 0; i < canary.Count && i < len(groupNodes); i++ {
+			isCanary[groupNodes[i]] = true
+		}
+	}
+	for _, node := range groupNodes {
+		if isCanary[node] {
+			canaryNodes = append(canaryNodes, node)
+		} else {
+			otherNodes = append(otherNodes, node)
+		}
+	}
+	return
+}
+
+// GetGroupMaxParallel gets the number of nodes of the specified group that can be processed at the same time.
+// If it is not specified, or is invalid, 1 is returned.
+func (config *UpgradeConfig) GetGroupMaxParallel(groupName string) (result int) {
+	result = config.Common.MaxParallel[groupName]
+	if result < 1 {
+		result = 1
+	}
+	return
+}
+
+// GetNodeSoftwareGroup gets the name of the software group that has the software on the node,
+// or an empty string if there's no such group.
+func (config *UpgradeConfig) GetNodeSoftwareGroup(node, software string) string {
+	for _, groupName := range config.GetGroupNames() {
+		if stringInSlice(node, config.GetGroupNodes(groupName)) && stringInSlice(software, config.GetGroupSoftware(groupName)) {
+			return groupName
+		}
+	}
+	return ""
+}
+
 // GetGroupNodes gets the nodes belonging to the spcified group
 func (config *UpgradeConfig) GetGroupNodes(groupName string) (result []string) {
 	result = config.SoftwareGroupNodes[groupName]
@@ -423,6 +631,10 @@ func (config *UpgradeConfig) VerifyFilesExist() (err error) {
 
 	for softwareKey, softwareInfo := range config.Software {
 		for _, fileInfo := range softwareInfo.Copy {
+			// URLs are checked when they are fetched by ResolveArtifacts
+			if IsURL(fileInfo.SourceFilePath) {
+				continue
+			}
 			if !FileExists(fileInfo.SourceFilePath) {
 				msg = fmt.Sprintf("%sFile does not exist in %s: %v\n", msg, softwareKey, fileInfo.SourceFilePath)
 			} else {
@@ -448,58 +660,97 @@ func (config *UpgradeConfig) GetNodes() (result []string) {
 	return
 }
 
-// GetNodeUpgradeInfo gets the specific upgrade information for a particular node's software.
-func (config *UpgradeConfig) GetNodeUpgradeInfo(node, software string) (result *NodeInfoContainer) {
-	result = &NodeInfoContainer{}
-	nodeInfo := config.Nodes[node]
-	if len(nodeInfo.PostUpgrade) > 0 {
-		result.PostUpgrade = nodeInfo.PostUpgrade
-	} else {
-		result.PostUpgrade = config.Software[software].PostUpgrade
-	}
-	if len(nodeInfo.PreUpgrade) > 0 {
-		result.PreUpgrade = nodeInfo.PreUpgrade
-	} else {
-		result.PreUpgrade = config.Software[software].PreUpgrade
-	}
-	if nodeInfo.StartCmd != "" {
-		result.StartCmd = nodeInfo.StartCmd
-	} else {
-		result.StartCmd = config.Software[software].StartCmd
-	}
-	if nodeInfo.StopCmd != "" {
-		result.StopCmd = nodeInfo.StopCmd
-	} else {
-		result.StopCmd = config.Software[software].StopCmd
-	}
+// GetNodeSSHInfo gets the SSH settings of the node, the settings that the node doesn't specify are taken from common.
+func (config *UpgradeConfig) GetNodeSSHInfo(node string) (result SSHInfo) {
+	nodeInfo := config.Nodes[node].SSHInfo
+	result = config.Common.SSHInfo
 	if nodeInfo.SSHUserName != "" {
 		result.SSHUserName = nodeInfo.SSHUserName
-	} else {
-		result.SSHUserName = config.Common.SSHUserName
 	}
 	if nodeInfo.SSHCert != "" {
 		result.SSHCert = nodeInfo.SSHCert
-	} else {
-		result.SSHCert = config.Common.SSHCert
 	}
-	if len(nodeInfo.Copy) > 0 {
-		result.Copy = nodeInfo.Copy
-		result.Exec = nodeInfo.Exec
-	} else {
-		result.Copy = config.Software[software].Copy
-		result.Exec = config.Software[software].Exec
+	if nodeInfo.SSHPassphrase != "" {
+		result.SSHPassphrase = nodeInfo.SSHPassphrase
 	}
+	if len(nodeInfo.SSHAuth) > 0 {
+		result.SSHAuth = nodeInfo.SSHAuth
+	}
+	if nodeInfo.SSHKnownHosts != "" {
+		result.SSHKnownHosts = nodeInfo.SSHKnownHosts
+	}
+	if nodeInfo.SSHHostKeyPolicy != "" {
+		result.SSHHostKeyPolicy = nodeInfo.SSHHostKeyPolicy
+	}
+	if len(nodeInfo.SSHJumpHosts) > 0 {
+		result.SSHJumpHosts = nodeInfo.SSHJumpHosts
+	}
+	return
+}
+
+// GetNodeUpgradeInfo gets the specific upgrade information for a particular node's software.
+// The placeholders in the commands are expanded, commands with placeholders that can't be expanded are left unexpanded,
+// ValidateCommands reports them.
+func (config *UpgradeConfig) GetNodeUpgradeInfo(node, software string) (result *NodeInfoContainer) {
+	result, err := config.getNodeUpgradeInfo(node, software)
+	if err != nil {
+		DebugLog.Printf("Node %s: unable to expand the commands of software %s: %v\n", node, software, err)
+	}
+	return
+}
 
+// ValidateCommands verifies that the placeholders in the commands of every node's software can be expanded.
+func (config *UpgradeConfig) ValidateCommands() (err error) {
+	var msg string
+	for _, groupName := range config.GetGroupNames() {
+		for _, node := range config.GetGroupNodes(groupName) {
+			for _, software := range config.GetGroupSoftware(groupName) {
+				if _, expandErr := config.getNodeUpgradeInfo(node, software); expandErr != nil {
+					msg = fmt.Sprintf("%sNode %s, software %s: %v\n", msg, node, software, expandErr)
+				}
+			}
+		}
+	}
+	if msg != "" {
+		err = errors.New(msg)
+	}
+	return
+}
+
+func (config *UpgradeConfig) getNodeUpgradeInfo(node, software string) (result *NodeInfoContainer, err error) {
+	result = &NodeInfoContainer{}
+	nodeInfo := config.Nodes[node]
+	group := config.GetNodeSoftwareGroup(node, software)
+
+	// The software defaults are overridden by the software group, which is overridden by the node
+	result.UpgradeInfo = mergeUpgradeInfo(config.Software[software], config.GroupOverrides[group][software], nodeInfo.UpgradeInfo)
+	result.SSHInfo = config.GetNodeSSHInfo(node)
+	result.Vars = nodeInfo.Vars
+	result.TemplateData = TemplateData{
+		Node:         node,
+		Group:        group,
+		Software:     software,
+		BackupSuffix: GetBackupSuffix(),
+		Vars:         nodeInfo.Vars,
+	}
+
+	// The merged Copy map belongs to this node, as it's updated while upgrading the node.
 	// assign backup strategy as copy if it is not speficied.
 	// also assign transfer verification
-	for k := range result.Copy {
-		if result.Copy[k].BackupStrategy == "" {
-			temp := result.Copy[k]
-			temp.BackupStrategy = "copy"
-			temp.VerifyCopy = "sha256"
-			result.Copy[k] = temp
+	for k, upgradeStruct := range result.Copy {
+		if cachedPath, ok := config.artifacts[artifactKey(upgradeStruct)]; ok {
+			upgradeStruct.SourceFilePath = cachedPath
+		}
+		if upgradeStruct.BackupStrategy == "" {
+			upgradeStruct.BackupStrategy = "copy"
+			upgradeStruct.VerifyCopy = "sha256"
 		}
+		result.Copy[k] = upgradeStruct
+	}
+	if indexes := result.copyIndexes(); len(indexes) > 0 {
+		result.TemplateData.RemoteFilename = result.Copy[indexes[0]].DestFilePath
 	}
+	err = result.expandCommands()
 
 	if (len(result.Copy) == 0) || (len(result.PreUpgrade) == 0) || (len(result.PostUpgrade) == 0) ||
 		(result.SSHCert == "") || (result.SSHUserName == "") || (result.StartCmd == "") || (result.StopCmd == "") {
@@ -517,16 +768,22 @@ func NewFailedUpgradeInfo() *FailedUpgradeInfo {
 
 // Clear clears the mapping
 func (failedUpgradeInfo *FailedUpgradeInfo) Clear() {
+	failedUpgradeInfo.mutex.Lock()
+	defer failedUpgradeInfo.mutex.Unlock()
 	failedUpgradeInfo.FailedNodeSoftware = nil
 }
 
 // GetNodeSoftwareCount gets the number of failed upgrades for a particular node
 func (failedUpgradeInfo *FailedUpgradeInfo) GetNodeSoftwareCount(node string) int {
+	failedUpgradeInfo.mutex.Lock()
+	defer failedUpgradeInfo.mutex.Unlock()
 	return len(failedUpgradeInfo.FailedNodeSoftware[node])
 }
 
 // GetCount returns the total number of values currently available
 func (failedUpgradeInfo *FailedUpgradeInfo) GetCount() (totalCount int) {
+	failedUpgradeInfo.mutex.Lock()
+	defer failedUpgradeInfo.mutex.Unlock()
 	for k := range failedUpgradeInfo.FailedNodeSoftware {
 		totalCount += len(failedUpgradeInfo.FailedNodeSoftware[k])
 	}
@@ -538,8 +795,10 @@ func (failedUpgradeInfo *FailedUpgradeInfo) AddNodeSoftware(node, software strin
 	if failedUpgradeInfo == nil {
 		panic("Iniatialize failedUpgradeInfo first!")
 	}
+	failedUpgradeInfo.mutex.Lock()
+	defer failedUpgradeInfo.mutex.Unlock()
 	// Do not allow duplicates
-	if failedUpgradeInfo.ExistsNodeSoftware(node, software) {
+	if failedUpgradeInfo.existsNodeSoftware(node, software) {
 		return
 	}
 	softwares := failedUpgradeInfo.FailedNodeSoftware[node]
@@ -549,13 +808,25 @@ func (failedUpgradeInfo *FailedUpgradeInfo) AddNodeSoftware(node, software strin
 
 // Empty returns true if failedUpgradeInfo's FailedNodeSoftware does not have any keys
 func (failedUpgradeInfo *FailedUpgradeInfo) Empty() (empty bool) {
+	failedUpgradeInfo.mutex.Lock()
+	defer failedUpgradeInfo.mutex.Unlock()
 	empty = len(failedUpgradeInfo.FailedNodeSoftware) == 0
 	return
 }
 
 // ExistsNodeSoftware returns true if a particular software for a nade exists in the failed upgrade info
 func (failedUpgradeInfo *FailedUpgradeInfo) ExistsNodeSoftware(node, software string) (result bool) {
-	if failedUpgradeInfo == nil || failedUpgradeInfo.FailedNodeSoftware == nil {
+	if failedUpgradeInfo == nil {
+		return false
+	}
+	failedUpgradeInfo.mutex.Lock()
+	defer failedUpgradeInfo.mutex.Unlock()
+	return failedUpgradeInfo.existsNodeSoftware(node, software)
+}
+
+// existsNodeSoftware is ExistsNodeSoftware without locking, the caller must hold the mutex.
+func (failedUpgradeInfo *FailedUpgradeInfo) existsNodeSoftware(node, software string) (result bool) {
+	if failedUpgradeInfo.FailedNodeSoftware == nil {
 		return false
 	}
 	softwares := failedUpgradeInfo.FailedNodeSoftware[node]
@@ -569,6 +840,8 @@ func (failedUpgradeInfo *FailedUpgradeInfo) ExistsNodeSoftware(node, software st
 
 // RemoveNodeSoftware removes a software from a node
 func (failedUpgradeInfo *FailedUpgradeInfo) RemoveNodeSoftware(node, software string) {
+	failedUpgradeInfo.mutex.Lock()
+	defer failedUpgradeInfo.mutex.Unlock()
 	softwares := failedUpgradeInfo.FailedNodeSoftware[node]
 	for i, v := range softwares {
 		if v == software {
@@ -587,6 +860,8 @@ func (failedUpgradeInfo *FailedUpgradeInfo) RemoveNodeSoftware(node, software st
 
 // FindNode returns the software for a node
 func (failedUpgradeInfo *FailedUpgradeInfo) FindNode(node string) []string {
+	failedUpgradeInfo.mutex.Lock()
+	defer failedUpgradeInfo.mutex.Unlock()
 	return failedUpgradeInfo.FailedNodeSoftware[node]
 }
 
@@ -594,6 +869,8 @@ func (failedUpgradeInfo *FailedUpgradeInfo) FindNode(node string) []string {
 func NewRollbackSession(aSessionSuffix string) (result *RollbackSession) {
 	result = &RollbackSession{
 		aSessionSuffix,
-		NewFailedUpgradeInfo(), ""}
+		NewFailedUpgradeInfo(), "",
+		NewFailedUpgradeInfo(),
+		NewVersionInfo(), nil}
 	return
 }
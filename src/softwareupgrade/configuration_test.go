@@ -1,6 +1,9 @@
 package softwareupgrade
 
 import (
+	"encoding/json"
+	"fmt"
+	"sync"
 	"testing"
 )
 
@@ -87,3 +90,130 @@ func TestFailedUpgradeInfo_GetCount(t *testing.T) {
 		t.Fatalf("%s %d", CGetCountShouldReturn, 2)
 	}
 }
+
+func TestFailedUpgradeInfo_ConcurrentAddRemoveNodeSoftware(t *testing.T) {
+	failedUpgradeInfo := NewFailedUpgradeInfo()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			failedUpgradeInfo.AddNodeSoftware(node, "s1")
+			failedUpgradeInfo.AddNodeSoftware(node, "s2")
+			failedUpgradeInfo.RemoveNodeSoftware(node, "s1")
+		}(fmt.Sprintf("node%d", i))
+	}
+	wg.Wait()
+	if count := failedUpgradeInfo.GetCount(); count != 50 {
+		t.Fatalf("%s %d, but returned %d", CGetCountShouldReturn, 50, count)
+	}
+}
+
+func TestUpgradeConfig_GetGroupMaxParallel(t *testing.T) {
+	var config UpgradeConfig
+	if maxParallel := config.GetGroupMaxParallel("Quorum-Makers"); maxParallel != 1 {
+		t.Fatalf("GetGroupMaxParallel should return 1 when max_parallel is missing, but returned %d", maxParallel)
+	}
+	config.Common.MaxParallel = map[string]int{"Quorum-Makers": 4, "Bootnodes": -1}
+	if maxParallel := config.GetGroupMaxParallel("Quorum-Makers"); maxParallel != 4 {
+		t.Fatalf("GetGroupMaxParallel should return 4, but returned %d", maxParallel)
+	}
+	if maxParallel := config.GetGroupMaxParallel("Bootnodes"); maxParallel != 1 {
+		t.Fatalf("GetGroupMaxParallel should return 1 for an invalid value, but returned %d", maxParallel)
+	}
+}
+
+func TestUpgradeConfig_GetGroupCanaryNodes(t *testing.T) {
+	var config UpgradeConfig
+	config.SoftwareGroupNodes = map[string][]string{"Quorum-Validators": {"node1", "node2", "node3"}}
+	canaryNodes, otherNodes := config.GetGroupCanaryNodes("Quorum-Validators")
+	if len(canaryNodes) != 0 || len(otherNodes) != 3 {
+		t.Fatalf("There should be no canary nodes, but got %v, %v", canaryNodes, otherNodes)
+	}
+
+	config.Common.Canary = map[string]CanaryInfo{"Quorum-Validators": {Count: 2}}
+	canaryNodes, otherNodes = config.GetGroupCanaryNodes("Quorum-Validators")
+	if fmt.Sprint(canaryNodes) != "[node1 node2]" || fmt.Sprint(otherNodes) != "[node3]" {
+		t.Fatalf("Canary count isn't handled, got %v, %v", canaryNodes, otherNodes)
+	}
+
+	config.Common.Canary = map[string]CanaryInfo{"Quorum-Validators": {Nodes: []string{"node3"}, Count: 2}}
+	canaryNodes, otherNodes = config.GetGroupCanaryNodes("Quorum-Validators")
+	if fmt.Sprint(canaryNodes) != "[node3]" || fmt.Sprint(otherNodes) != "[node1 node2]" {
+		t.Fatalf("Canary nodes aren't handled, got %v, %v", canaryNodes, otherNodes)
+	}
+}
+
+func TestUpgradeConfig_GetNodeUpgradeInfo(t *testing.T) {
+	var config UpgradeConfig
+	config.Software = map[string]UpgradeInfo{
+		"quorum": {
+			StartCmd:  "sudo supervisorctl start quorum",
+			OnFailure: COnFailureRollback,
+			Copy: map[string]UpgradeStruct{
+				"1": {SourceFilePath: "/tmp/geth", DestFilePath: "/usr/local/bin/geth"},
+			},
+		},
+	}
+	config.Nodes = map[string]NodeInfoContainer{
+		"node2": {UpgradeInfo: UpgradeInfo{OnFailure: "none"}},
+	}
+
+	nodeInfo := config.GetNodeUpgradeInfo("node1", "quorum")
+	if nodeInfo.OnFailure != COnFailureRollback {
+		t.Fatalf("OnFailure should be %s, but is %s", COnFailureRollback, nodeInfo.OnFailure)
+	}
+	if upgradeStruct := nodeInfo.Copy["1"]; upgradeStruct.BackupStrategy != "copy" || upgradeStruct.VerifyCopy != "sha256" {
+		t.Fatalf("Default backup strategy and verification aren't assigned: %+v", upgradeStruct)
+	}
+	upgradeStruct := nodeInfo.Copy["1"]
+	upgradeStruct.UserGroup = "ubuntu:ubuntu"
+	nodeInfo.Copy["1"] = upgradeStruct
+	if config.Software["quorum"].Copy["1"].UserGroup != "" || config.GetNodeUpgradeInfo("node2", "quorum").Copy["1"].UserGroup != "" {
+		t.Fatal("Updating the Copy of a node must not change the configuration")
+	}
+	if nodeInfo := config.GetNodeUpgradeInfo("node2", "quorum"); nodeInfo.OnFailure != "none" {
+		t.Fatalf("OnFailure of the node should override the software, but is %s", nodeInfo.OnFailure)
+	}
+}
+
+func TestNodeInfoContainer_IsUnchanged(t *testing.T) {
+	nodeInfo := NodeInfoContainer{}
+	local := testHasher{"geth": "aaaa", "bootnode": "bbbb"}
+	remote := testHasher{"/usr/local/bin/geth": "aaaa", "/usr/local/bin/bootnode": "bbbb"}
+	if unchanged, _ := nodeInfo.IsUnchanged(remote, local); unchanged {
+		t.Fatal("Software without files to copy shouldn't be unchanged")
+	}
+
+	nodeInfo.Copy = map[string]UpgradeStruct{
+		"1": {SourceFilePath: "geth", DestFilePath: "/usr/local/bin/geth"},
+		"2": {SourceFilePath: "bootnode", DestFilePath: "/usr/local/bin/bootnode"},
+	}
+	if unchanged, err := nodeInfo.IsUnchanged(remote, local); !unchanged || err != nil {
+		t.Fatalf("Software with identical files should be unchanged, error: %v", err)
+	}
+	remote["/usr/local/bin/bootnode"] = "cccc"
+	if unchanged, _ := nodeInfo.IsUnchanged(remote, local); unchanged {
+		t.Fatal("Software with a different file shouldn't be unchanged")
+	}
+	delete(remote, "/usr/local/bin/bootnode")
+	if unchanged, _ := nodeInfo.IsUnchanged(remote, local); unchanged {
+		t.Fatal("Software with a missing file shouldn't be unchanged")
+	}
+}
+
+func TestRollbackSession_AddDecision(t *testing.T) {
+	session := NewRollbackSession("20181010")
+	session.AddDecision("upgrade", "node", "node1", "skip")
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewRollbackSession("")
+	if err = json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Decisions) != 1 || loaded.Decisions[0].Name != "node1" || loaded.Decisions[0].Choice != "skip" {
+		t.Fatalf("The decision should be saved in the rollback session, but decisions are %v", loaded.Decisions)
+	}
+}
@@ -0,0 +1,50 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// ConsensusInfo specifies how many nodes of a software group can be stopped at the same time
+	// without the network losing consensus.
+	ConsensusInfo struct {
+		Mode           string `json:"mode"`            // either ibft or raft, computes the fault tolerance from the number of nodes in the group
+		FaultTolerance int    `json:"fault_tolerance"` // the number of nodes that can be stopped at the same time, overrides mode
+	}
+)
+
+// GetGroupFaultTolerance gets the number of nodes of the specified group that can be stopped at the same time.
+// constrained is false if the group doesn't have a consensus constraint, in which case tolerance is meaningless.
+func (config *UpgradeConfig) GetGroupFaultTolerance(groupName string) (tolerance int, constrained bool, err error) {
+	consensus, constrained := config.Common.Consensus[groupName]
+	if !constrained {
+		return
+	}
+	if consensus.FaultTolerance > 0 {
+		tolerance = consensus.FaultTolerance
+		return
+	}
+	nodeCount := len(config.GetGroupNodes(groupName))
+	switch strings.ToLower(consensus.Mode) {
+	case CConsensusIBFT:
+		{
+			// IBFT tolerates f faulty nodes out of 3f+1 nodes
+			tolerance = (nodeCount - 1) / 3
+		}
+	case CConsensusRaft:
+		{
+			// Raft needs a majority of the nodes to be up
+			tolerance = (nodeCount - 1) / 2
+		}
+	default:
+		{
+			err = fmt.Errorf("Unknown consensus mode: %s for software group: %s", consensus.Mode, groupName)
+			return
+		}
+	}
+	if tolerance < 0 {
+		tolerance = 0
+	}
+	return
+}
@@ -0,0 +1,36 @@
+package softwareupgrade
+
+import (
+	"testing"
+)
+
+func TestUpgradeConfig_GetGroupFaultTolerance(t *testing.T) {
+	var config UpgradeConfig
+	config.SoftwareGroupNodes = map[string][]string{
+		"Quorum-Validators": {"node1", "node2", "node3", "node4", "node5", "node6", "node7"},
+	}
+	if _, constrained, err := config.GetGroupFaultTolerance("Quorum-Validators"); constrained || err != nil {
+		t.Fatal("Group without consensus shouldn't be constrained")
+	}
+
+	tests := []struct {
+		consensus ConsensusInfo
+		tolerance int
+	}{
+		{ConsensusInfo{Mode: "IBFT"}, 2},
+		{ConsensusInfo{Mode: "raft"}, 3},
+		{ConsensusInfo{Mode: "ibft", FaultTolerance: 1}, 1},
+	}
+	for _, test := range tests {
+		config.Common.Consensus = map[string]ConsensusInfo{"Quorum-Validators": test.consensus}
+		tolerance, constrained, err := config.GetGroupFaultTolerance("Quorum-Validators")
+		if !constrained || err != nil || tolerance != test.tolerance {
+			t.Fatalf("Fault tolerance for %+v should be %d, but is %d, error: %v", test.consensus, test.tolerance, tolerance, err)
+		}
+	}
+
+	config.Common.Consensus = map[string]ConsensusInfo{"Quorum-Validators": {Mode: "pow"}}
+	if _, _, err := config.GetGroupFaultTolerance("Quorum-Validators"); err == nil {
+		t.Fatal("Unknown consensus mode should be rejected")
+	}
+}
@@ -14,6 +14,34 @@ const (
 	CStop       string = "Stop "
 	CNodeMsgSSS string = "Node %s: %s: %v"
 
+	COnFailureRollback string = "rollback"
+
+	CConsensusIBFT string = "ibft"
+	CConsensusRaft string = "raft"
+
+	CHealthCheckGeth string = "geth"
+
+	CCopyTypeArchive   string = "archive"
+	CCopyTypeDirectory string = "directory"
+
+	CConfigFormatJSON string = "json"
+	CConfigFormatYAML string = "yaml"
+	CConfigFormatTOML string = "toml"
+	CConfigInclude    string = "include"
+
+	CSSHAuthAgent            string = "agent"
+	CSSHAuthKey              string = "key"
+	CSSHAuthKeyPrefix        string = "key:"
+	CSSHAuthSock             string = "SSH_AUTH_SOCK"
+	CSSHPassphrasePrompt     string = "prompt"
+	CSSHPassphraseEnvPrefix  string = "env:"
+	CSSHPassphraseFilePrefix string = "file:"
+
+	CHostKeyPolicyStrict    string = "strict"
+	CHostKeyPolicyAcceptNew string = "accept-new"
+	CHostKeyPolicyOff       string = "off"
+	CDefaultKnownHosts      string = "~/.ssh/known_hosts"
+
 	CEximchainUpgradeTitle string = "Eximchain Blockchain Software Upgrade v0.4"
 	CGetCountShouldReturn  string = "GetCount() should return"
 )
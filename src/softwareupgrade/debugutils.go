@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"sync"
 )
 
 type (
@@ -15,6 +16,7 @@ type (
 		printDebug   bool
 		printConsole bool
 		file         *os.File
+		mutex        sync.Mutex // keeps the console and the log file in the same order when logging from multiple goroutines
 	}
 )
 
@@ -72,6 +74,10 @@ func (d *TDebugLog) GetFilename() (result string) {
 
 // Print decides whether the debug log is sent to the console, or not, and also logs it to the debug log
 func (d *TDebugLog) Print(format string, args ...interface{}) {
+	if d != nil {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+	}
 	if d != nil && d.printConsole {
 		fmt.Printf(format, args...)
 	}
@@ -0,0 +1,182 @@
+package softwareupgrade
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Downloader fetches artifacts specified by URLs into a local cache, where each artifact is named by its sha256 hash.
+	// It is safe to be used by multiple goroutines.
+	Downloader struct {
+		CacheDir string
+		Client   *http.Client
+		mutex    sync.Mutex
+	}
+)
+
+// NewDownloader creates a downloader that caches artifacts in the given directory
+func NewDownloader(cacheDir string) *Downloader {
+	return &Downloader{
+		CacheDir: cacheDir,
+		Client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// IsURL returns true if the source is a http, https or file URL
+func IsURL(source string) bool {
+	lowerSource := strings.ToLower(source)
+	return strings.HasPrefix(lowerSource, "http://") || strings.HasPrefix(lowerSource, "https://") ||
+		strings.HasPrefix(lowerSource, "file://")
+}
+
+// Fetch returns the path of the artifact in the cache, downloading it if it's not in the cache yet.
+// The artifact must have the given sha256 hash, otherwise it's not cached, and an error is returned.
+func (downloader *Downloader) Fetch(source, expectedSha256 string) (cachedPath string, err error) {
+	expectedSha256 = strings.ToLower(strings.TrimSpace(expectedSha256))
+	if _, decodeErr := hex.DecodeString(expectedSha256); len(expectedSha256) != sha256.Size*2 || decodeErr != nil {
+		return "", fmt.Errorf("%s requires a valid sha256", source)
+	}
+	cacheDir, err := Expand(downloader.CacheDir)
+	if err != nil {
+		return
+	}
+
+	// Only fetch one artifact at a time, so the same artifact is never downloaded twice
+	downloader.mutex.Lock()
+	defer downloader.mutex.Unlock()
+
+	cachedPath = filepath.Join(cacheDir, expectedSha256)
+	if hash, hashErr := fileSha256(cachedPath); hashErr == nil && hash == expectedSha256 {
+		return
+	}
+	if err = os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+
+	reader, err := downloader.open(source)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	tempFile, err := ioutil.TempFile(cacheDir, expectedSha256+".")
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tempFile, hasher), reader)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if hash := hex.EncodeToString(hasher.Sum(nil)); hash != expectedSha256 {
+			err = fmt.Errorf("sha256 of %s is %s, expected %s", source, hash, expectedSha256)
+		}
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), cachedPath)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	return
+}
+
+// open opens the artifact specified by the URL for reading
+func (downloader *Downloader) open(source string) (reader io.ReadCloser, err error) {
+	sourceURL, err := url.Parse(source)
+	if err != nil {
+		return
+	}
+	switch strings.ToLower(sourceURL.Scheme) {
+	case "file":
+		{
+			return os.Open(sourceURL.Path)
+		}
+	case "http", "https":
+		{
+			var response *http.Response
+			if response, err = downloader.Client.Get(source); err != nil {
+				return
+			}
+			if response.StatusCode != http.StatusOK {
+				response.Body.Close()
+				return nil, fmt.Errorf("unable to download %s: %s", source, response.Status)
+			}
+			return response.Body, nil
+		}
+	}
+	return nil, errors.New("unsupported URL: " + source)
+}
+
+// fileSha256 calculates the sha256 hash of a local file
+func fileSha256(filename string) (result string, err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return
+	}
+	result = hex.EncodeToString(hasher.Sum(nil))
+	return
+}
+
+// artifactKey identifies the artifact of the Copy entry by its URL and sha256.
+// It's empty if the entry's Local_Filename isn't a URL.
+func artifactKey(upgradeStruct UpgradeStruct) string {
+	if !IsURL(upgradeStruct.SourceFilePath) {
+		return ""
+	}
+	return upgradeStruct.SourceFilePath + " " + strings.ToLower(strings.TrimSpace(upgradeStruct.Sha256))
+}
+
+// ResolveArtifacts fetches every file to copy that is specified by a URL into the downloader's cache.
+// The URL and the sha256 are taken from the Copy of each node's software, once the software group and node overrides
+// are merged, so either can be overridden on its own. GetNodeUpgradeInfo then returns the path of the cached file.
+func (config *UpgradeConfig) ResolveArtifacts(downloader *Downloader) (err error) {
+	var msg string
+	artifacts := make(map[string]string)
+	failed := make(map[string]bool)
+	for _, groupName := range config.GetGroupNames() {
+		for _, node := range config.GetGroupNodes(groupName) {
+			for _, software := range config.GetGroupSoftware(groupName) {
+				nodeInfo, _ := config.getNodeUpgradeInfo(node, software)
+				for _, index := range nodeInfo.copyIndexes() {
+					upgradeStruct := nodeInfo.Copy[index]
+					key := artifactKey(upgradeStruct)
+					if key == "" || artifacts[key] != "" || failed[key] {
+						continue
+					}
+					cachedPath, fetchErr := downloader.Fetch(upgradeStruct.SourceFilePath, upgradeStruct.Sha256)
+					if fetchErr != nil {
+						failed[key] = true
+						msg = fmt.Sprintf("%sUnable to fetch artifact for node %s, software %s: %v\n", msg, node, software, fetchErr)
+						continue
+					}
+					artifacts[key] = cachedPath
+				}
+			}
+		}
+	}
+	config.artifacts = artifacts
+	if msg != "" {
+		err = errors.New(msg)
+	}
+	return
+}
@@ -0,0 +1,124 @@
+package softwareupgrade
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDownloader_Fetch(t *testing.T) {
+	content := []byte("geth 1.8.18 binary")
+	hash := sha256.Sum256(content)
+	contentSha256 := hex.EncodeToString(hash[:])
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path != "/geth" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	downloader := NewDownloader(cacheDir)
+
+	for i := 0; i < 2; i++ {
+		cachedPath, err := downloader.Fetch(server.URL+"/geth", contentSha256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cachedPath != filepath.Join(cacheDir, contentSha256) {
+			t.Fatalf("Artifact should be cached by its hash, but is cached in %s", cachedPath)
+		}
+		if data, _ := ioutil.ReadFile(cachedPath); string(data) != string(content) {
+			t.Fatal("Cached artifact doesn't have the downloaded content")
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("Artifact should be downloaded once, but was downloaded %d times", requests)
+	}
+
+	wrongSha256 := hex.EncodeToString(make([]byte, sha256.Size))
+	if _, err = downloader.Fetch(server.URL+"/geth", wrongSha256); err == nil {
+		t.Fatal("Fetch should fail when the sha256 doesn't match")
+	}
+	if FileExists(filepath.Join(cacheDir, wrongSha256)) {
+		t.Fatal("Artifact with the wrong sha256 shouldn't be cached")
+	}
+	if _, err = downloader.Fetch(server.URL+"/missing", wrongSha256); err == nil {
+		t.Fatal("Fetch should fail when the artifact doesn't exist")
+	}
+	if _, err = downloader.Fetch(server.URL+"/geth", ""); err == nil {
+		t.Fatal("Fetch should fail without a sha256")
+	}
+	if files, _ := ioutil.ReadDir(cacheDir); len(files) != 1 {
+		t.Fatalf("Cache should only contain the verified artifact, but contains %d files", len(files))
+	}
+}
+
+func TestUpgradeConfig_ResolveArtifacts(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	content := []byte("vault binary")
+	hash := sha256.Sum256(content)
+	sourceFilename := filepath.Join(tempDir, "vault")
+	if err = ioutil.WriteFile(sourceFilename, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the group override only changes the URL, and relies on the sha256 of the software
+	mirrorFilename := filepath.Join(tempDir, "mirror")
+	if err = ioutil.WriteFile(mirrorFilename, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var config UpgradeConfig
+	config.Software = map[string]UpgradeInfo{
+		"vault": {Copy: map[string]UpgradeStruct{
+			"1": {SourceFilePath: "file://" + sourceFilename, Sha256: hex.EncodeToString(hash[:])},
+			"2": {SourceFilePath: "/tmp/vault.hcl"},
+		}},
+	}
+	config.Common.SoftwareGroup = map[string][]string{"VaultServers": {"vault"}}
+	config.SoftwareGroupNodes = map[string][]string{"VaultServers": {"node1"}}
+	config.GroupOverrides = map[string]map[string]UpgradeInfo{"VaultServers": {"vault": {Copy: map[string]UpgradeStruct{
+		"1": {SourceFilePath: "file://" + mirrorFilename},
+	}}}}
+	cacheDir := filepath.Join(tempDir, "cache")
+	if err = config.ResolveArtifacts(NewDownloader(cacheDir)); err != nil {
+		t.Fatal(err)
+	}
+	nodeInfo := config.GetNodeUpgradeInfo("node1", "vault")
+	if path := nodeInfo.Copy["1"].SourceFilePath; path != filepath.Join(cacheDir, hex.EncodeToString(hash[:])) {
+		t.Fatalf("URL should be replaced by the cached path, but is %s", path)
+	}
+	if path := nodeInfo.Copy["2"].SourceFilePath; path != "/tmp/vault.hcl" {
+		t.Fatalf("Local filename shouldn't be changed, but is %s", path)
+	}
+
+	// the node override only changes the sha256, so the URL is fetched again, and doesn't match
+	config.SoftwareGroupNodes["VaultServers"] = append(config.SoftwareGroupNodes["VaultServers"], "node2")
+	otherHash := sha256.Sum256([]byte("other vault binary"))
+	config.Nodes = map[string]NodeInfoContainer{"node2": {UpgradeInfo: UpgradeInfo{Copy: map[string]UpgradeStruct{
+		"1": {Sha256: hex.EncodeToString(otherHash[:])},
+	}}}}
+	if err = config.ResolveArtifacts(NewDownloader(cacheDir)); err == nil || !strings.Contains(err.Error(), "node2") {
+		t.Fatalf("The sha256 overridden for node2 should be checked, but the error is %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package softwareupgrade
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v2"
+)
+
+// GetConfigFormat returns the format of the configuration file from its extension: json, yaml or toml
+func GetConfigFormat(filename string) (result string, err error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		{
+			result = CConfigFormatJSON
+		}
+	case ".yaml", ".yml":
+		{
+			result = CConfigFormatYAML
+		}
+	case ".toml":
+		{
+			result = CConfigFormatTOML
+		}
+	default:
+		{
+			err = fmt.Errorf("Unknown configuration format for %s, the extension must be .json, .yaml, .yml or .toml", filename)
+		}
+	}
+	return
+}
+
+// ConfigToJSON translates the configuration from the given format into JSON, so that it's parsed and validated
+// the same way regardless of its format.
+func ConfigToJSON(data []byte, format string) (result []byte, err error) {
+	if format == CConfigFormatJSON {
+		return data, nil
+	}
+	return ConvertConfig(data, format, CConfigFormatJSON)
+}
+
+// ConvertConfig translates the configuration between JSON, YAML and TOML, preserving every value and its type.
+// Comments are not preserved.
+func ConvertConfig(data []byte, from, to string) (result []byte, err error) {
+	value, err := decodeConfigValue(data, from)
+	if err != nil {
+		return
+	}
+	return encodeConfigValue(value, to)
+}
+
+// decodeConfigValue decodes the configuration in the given format into generic values, like JSON
+func decodeConfigValue(data []byte, format string) (result interface{}, err error) {
+	switch format {
+	case CConfigFormatJSON:
+		{
+			result, err = decodeJSONValue(data)
+		}
+	case CConfigFormatYAML:
+		{
+			result, err = decodeYAMLValue(data)
+		}
+	case CConfigFormatTOML:
+		{
+			result, err = decodeTOMLValue(data)
+		}
+	default:
+		{
+			err = fmt.Errorf("Unknown configuration format: %s", format)
+		}
+	}
+	return
+}
+
+// encodeConfigValue encodes the generic values in the given format
+func encodeConfigValue(value interface{}, format string) (result []byte, err error) {
+	switch format {
+	case CConfigFormatJSON:
+		{
+			// commands often contain &&, so HTML characters aren't escaped
+			var buffer bytes.Buffer
+			encoder := json.NewEncoder(&buffer)
+			encoder.SetEscapeHTML(false)
+			encoder.SetIndent("", "    ")
+			if err = encoder.Encode(value); err == nil {
+				result = buffer.Bytes()
+			}
+		}
+	case CConfigFormatYAML:
+		{
+			result, err = yaml.Marshal(value)
+		}
+	case CConfigFormatTOML:
+		{
+			result, err = encodeTOMLValue(value)
+		}
+	default:
+		{
+			err = fmt.Errorf("Unknown configuration format: %s", format)
+		}
+	}
+	return
+}
+
+// decodeJSONValue decodes JSON into generic values, keeping integers as integers
+func decodeJSONValue(data []byte) (result interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&result); err != nil {
+		return
+	}
+	result = normalizeJSONNumbers(result)
+	return
+}
+
+func normalizeJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		{
+			for key := range v {
+				v[key] = normalizeJSONNumbers(v[key])
+			}
+		}
+	case []interface{}:
+		{
+			for i := range v {
+				v[i] = normalizeJSONNumbers(v[i])
+			}
+		}
+	case json.Number:
+		{
+			if i, err := v.Int64(); err == nil {
+				return i
+			}
+			f, _ := v.Float64()
+			return f
+		}
+	}
+	return value
+}
+
+// decodeYAMLValue decodes YAML into generic values, with string keys like JSON, eg, the key 1 of a Copy map becomes "1"
+func decodeYAMLValue(data []byte) (result interface{}, err error) {
+	if err = yaml.Unmarshal(data, &result); err != nil {
+		return
+	}
+	result, err = stringKeys("$", result)
+	return
+}
+
+func stringKeys(path string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		{
+			result := make(map[string]interface{})
+			for key, item := range v {
+				name := fmt.Sprint(key)
+				if _, ok := result[name]; ok {
+					return nil, fmt.Errorf("%s.%s: duplicate key", path, name)
+				}
+				converted, err := stringKeys(path+"."+name, item)
+				if err != nil {
+					return nil, err
+				}
+				result[name] = converted
+			}
+			return result, nil
+		}
+	case []interface{}:
+		{
+			for i := range v {
+				converted, err := stringKeys(fmt.Sprintf("%s[%d]", path, i), v[i])
+				if err != nil {
+					return nil, err
+				}
+				v[i] = converted
+			}
+		}
+	}
+	return value, nil
+}
+
+// decodeTOMLValue decodes TOML into generic values, arrays of tables become arrays of objects like JSON
+func decodeTOMLValue(data []byte) (result interface{}, err error) {
+	var document map[string]interface{}
+	if _, err = toml.Decode(string(data), &document); err != nil {
+		return
+	}
+	result = genericTOMLValue(document)
+	return
+}
+
+func genericTOMLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		{
+			for key := range v {
+				v[key] = genericTOMLValue(v[key])
+			}
+		}
+	case []map[string]interface{}:
+		{
+			result := make([]interface{}, len(v))
+			for i := range v {
+				result[i] = genericTOMLValue(v[i])
+			}
+			return result
+		}
+	case []interface{}:
+		{
+			for i := range v {
+				v[i] = genericTOMLValue(v[i])
+			}
+		}
+	}
+	return value
+}
+
+// encodeTOMLValue encodes the generic values as TOML, which must be a table at the top level and can't contain null
+func encodeTOMLValue(value interface{}) (result []byte, err error) {
+	if _, ok := value.(map[string]interface{}); !ok {
+		err = errors.New("TOML requires an object at the top level")
+		return
+	}
+	if err = checkTOMLNulls("$", value); err != nil {
+		return
+	}
+	var buffer bytes.Buffer
+	encoder := toml.NewEncoder(&buffer)
+	encoder.Indent = "    "
+	if err = encoder.Encode(value); err == nil {
+		result = buffer.Bytes()
+	}
+	return
+}
+
+func checkTOMLNulls(path string, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		{
+			return fmt.Errorf("%s: TOML can't represent null", path)
+		}
+	case map[string]interface{}:
+		{
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if err := checkTOMLNulls(path+"."+key, v[key]); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		{
+			for i := range v {
+				if err := checkTOMLNulls(fmt.Sprintf("%s[%d]", path, i), v[i]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
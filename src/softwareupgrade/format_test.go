@@ -0,0 +1,99 @@
+package softwareupgrade
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestConvertConfig_RoundTrip(t *testing.T) {
+	data, err := ioutil.ReadFile("../../LaunchUpgrade.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := decodeJSONValue(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// adds values of the other types that the configuration uses
+	config := original.(map[string]interface{})
+	config["common"].(map[string]interface{})["max_parallel"] = map[string]interface{}{"Quorum-Validators": int64(2)}
+	config["nodes"] = map[string]interface{}{
+		"18.232.179.208": map[string]interface{}{
+			"health_check": []interface{}{map[string]interface{}{"type": "geth", "min_peers": int64(3)}},
+			"Copy":         map[string]interface{}{"1": map[string]interface{}{"Template": true}},
+		},
+	}
+	if data, err = json.Marshal(config); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{CConfigFormatYAML, CConfigFormatTOML} {
+		encoded, err := ConvertConfig(data, CConfigFormatJSON, format)
+		if err != nil {
+			t.Fatalf("Unable to convert to %s: %v", format, err)
+		}
+		decoded, err := ConfigToJSON(encoded, format)
+		if err != nil {
+			t.Fatalf("Unable to convert from %s: %v", format, err)
+		}
+		roundTripped, err := decodeJSONValue(decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(original, roundTripped) {
+			t.Fatalf("Converting to %s and back should preserve the configuration, but got:\n%s", format, decoded)
+		}
+	}
+}
+
+func TestConfigToJSON_YAML(t *testing.T) {
+	data := []byte(`
+# comments are allowed
+common:
+  software_group:
+    Quorum-Validators: [quorum]
+groupnodes:
+  Quorum-Validators: [node1]
+software:
+  quorum:
+    stop: sudo supervisorctl stop quorum
+    Copy:
+      1:
+        Local_Filename: /tmp/geth
+        Remote_Filename: /usr/local/bin/geth
+        Permissions: 0755
+`)
+	jsonData, err := ConfigToJSON(data, CConfigFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config UpgradeConfig
+	json.Unmarshal(jsonData, &config)
+	if config.Software["quorum"].Copy["1"].DestFilePath != "/usr/local/bin/geth" {
+		t.Fatalf("The Copy key 1 should become \"1\", but the configuration is %s", jsonData)
+	}
+	// an unquoted 0755 is a number in YAML, so it's reported like it would be in JSON
+	problems := ValidateConfig(jsonData)
+	if len(problems) != 1 || problems[0].Path != "$.software.quorum.Copy.1.Permissions" {
+		t.Fatalf("Expected a problem with the permissions, but found %v", problems)
+	}
+}
+
+func TestConvertConfig_TOMLNull(t *testing.T) {
+	if _, err := ConvertConfig([]byte(`{"common": {"ssh_cert": null}}`), CConfigFormatJSON, CConfigFormatTOML); err == nil {
+		t.Fatal("Converting null to TOML should fail instead of losing the value")
+	}
+}
+
+func TestGetConfigFormat(t *testing.T) {
+	for filename, expected := range map[string]string{"a.json": CConfigFormatJSON, "a.YML": CConfigFormatYAML, "a.yaml": CConfigFormatYAML, "a.toml": CConfigFormatTOML} {
+		if format, err := GetConfigFormat(filename); err != nil || format != expected {
+			t.Fatalf("Format of %s should be %s, but is %s, error: %v", filename, expected, format, err)
+		}
+	}
+	if _, err := GetConfigFormat("a.txt"); err == nil {
+		t.Fatal("Unknown extensions should be rejected")
+	}
+}
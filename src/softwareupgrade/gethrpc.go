@@ -0,0 +1,110 @@
+package softwareupgrade
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// GethRPCClient calls the JSON-RPC API of geth/quorum
+	GethRPCClient struct {
+		url        string
+		httpClient *http.Client
+	}
+
+	// Dialer connects to the given address. SSHConfig implicitly implements this interface,
+	// in which case the address is as seen from the remote node.
+	Dialer interface {
+		Dial(network, address string) (net.Conn, error)
+	}
+
+	gethRPCRequest struct {
+		JSONRPC string        `json:"jsonrpc"`
+		Method  string        `json:"method"`
+		Params  []interface{} `json:"params"`
+		ID      int           `json:"id"`
+	}
+
+	gethRPCResponse struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+)
+
+// NewGethRPCClient creates a client that calls the JSON-RPC API at the given address, eg, 127.0.0.1:8545,
+// connecting to it using the given dialer.
+func NewGethRPCClient(address string, dialer Dialer, timeout time.Duration) *GethRPCClient {
+	return &GethRPCClient{
+		url: "http://" + address,
+		httpClient: &http.Client{
+			Transport: &http.Transport{Dial: dialer.Dial},
+			Timeout:   timeout,
+		},
+	}
+}
+
+func (client *GethRPCClient) call(method string, result interface{}) (err error) {
+	request, err := json.Marshal(gethRPCRequest{"2.0", method, []interface{}{}, 1})
+	if err != nil {
+		return
+	}
+	httpResponse, err := client.httpClient.Post(client.url, "application/json", bytes.NewReader(request))
+	if err != nil {
+		return
+	}
+	defer httpResponse.Body.Close()
+	body, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return
+	}
+	var response gethRPCResponse
+	if err = json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("%s: invalid response: %s", method, body)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s", method, response.Error.Message)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+func (client *GethRPCClient) callUint(method string) (result uint64, err error) {
+	var hex string
+	if err = client.call(method, &hex); err != nil {
+		return
+	}
+	return strconv.ParseUint(strings.TrimPrefix(hex, "0x"), 16, 64)
+}
+
+// BlockNumber returns the number of the most recent block
+func (client *GethRPCClient) BlockNumber() (uint64, error) {
+	return client.callUint("eth_blockNumber")
+}
+
+// PeerCount returns the number of peers currently connected
+func (client *GethRPCClient) PeerCount() (uint64, error) {
+	return client.callUint("net_peerCount")
+}
+
+// Syncing returns true if the node is currently syncing with the network
+func (client *GethRPCClient) Syncing() (result bool, err error) {
+	var raw json.RawMessage
+	if err = client.call("eth_syncing", &raw); err != nil {
+		return
+	}
+	// eth_syncing returns false when not syncing, otherwise an object with the sync status
+	if len(raw) == 0 {
+		return false, errors.New("eth_syncing: empty result")
+	}
+	return string(raw) != "false", nil
+}
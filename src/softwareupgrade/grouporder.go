@@ -0,0 +1,105 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/twmb/algoimpl/go/graph"
+)
+
+// GetGroupExecutionOrder returns the software groups in the order they should be processed.
+// Groups listed in group_order come first, in the given order, followed by the remaining groups in alphabetical order.
+// This order is then rearranged so that every group comes after the groups that it depends on.
+// An error is returned if depends_on or group_order refers to an unknown group, or if the dependencies contain a cycle.
+func (config *UpgradeConfig) GetGroupExecutionOrder() (result []string, err error) {
+	knownGroups := make(map[string]bool)
+	for groupName := range config.SoftwareGroupNodes {
+		knownGroups[groupName] = true
+	}
+	for groupName := range config.Common.SoftwareGroup {
+		knownGroups[groupName] = true
+	}
+
+	var msg string
+	preferredOrder := []string{}
+	listed := make(map[string]bool)
+	for _, groupName := range config.Common.GroupOrder {
+		if !knownGroups[groupName] {
+			msg = fmt.Sprintf("%sgroup_order refers to unknown software group: %s\n", msg, groupName)
+			continue
+		}
+		if !listed[groupName] {
+			listed[groupName] = true
+			preferredOrder = append(preferredOrder, groupName)
+		}
+	}
+	var remaining []string
+	for groupName := range knownGroups {
+		if !listed[groupName] {
+			remaining = append(remaining, groupName)
+		}
+	}
+	sort.Strings(remaining)
+	preferredOrder = append(preferredOrder, remaining...)
+
+	// TopologicalSort reverses the order in which unrelated nodes are made,
+	// so make the nodes in reverse to keep the preferred order.
+	g := graph.New(graph.Directed)
+	groupNodes := make(map[string]*graph.Node)
+	for i := len(preferredOrder) - 1; i >= 0; i-- {
+		groupName := preferredOrder[i]
+		groupNodes[groupName] = g.MakeNode()
+		*groupNodes[groupName].Value = groupName
+	}
+	dependentGroups := make([]string, 0, len(config.Common.DependsOn))
+	for groupName := range config.Common.DependsOn {
+		dependentGroups = append(dependentGroups, groupName)
+	}
+	sort.Strings(dependentGroups)
+	for _, groupName := range dependentGroups {
+		if !knownGroups[groupName] {
+			msg = fmt.Sprintf("%sdepends_on refers to unknown software group: %s\n", msg, groupName)
+			continue
+		}
+		for _, dependency := range config.Common.DependsOn[groupName] {
+			if !knownGroups[dependency] {
+				msg = fmt.Sprintf("%sSoftware group: %s depends on unknown software group: %s\n", msg, groupName, dependency)
+				continue
+			}
+			if dependency == groupName {
+				msg = fmt.Sprintf("%sSoftware group: %s depends on itself\n", msg, groupName)
+				continue
+			}
+			// the edge points from the dependency to the group that depends on it
+			g.MakeEdge(groupNodes[dependency], groupNodes[groupName])
+		}
+	}
+	for _, component := range g.StronglyConnectedComponents() {
+		if len(component) > 1 {
+			var cycle []string
+			for _, node := range component {
+				cycle = append(cycle, (*node.Value).(string))
+			}
+			sort.Strings(cycle)
+			msg = fmt.Sprintf("%sSoftware groups: %s depend on each other in a cycle\n", msg, strings.Join(cycle, ", "))
+		}
+	}
+	if msg != "" {
+		return nil, fmt.Errorf("%s", msg)
+	}
+
+	for _, node := range g.TopologicalSort() {
+		groupName := (*node.Value).(string)
+		// groups without nodes are only used to resolve the dependencies
+		if _, ok := config.SoftwareGroupNodes[groupName]; ok {
+			result = append(result, groupName)
+		}
+	}
+	return
+}
+
+// GetGroupDependencies returns the software groups that the specified group depends on
+func (config *UpgradeConfig) GetGroupDependencies(groupName string) []string {
+	return config.Common.DependsOn[groupName]
+}
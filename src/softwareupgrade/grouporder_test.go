@@ -0,0 +1,73 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func newGroupOrderConfig() (config *UpgradeConfig) {
+	config = &UpgradeConfig{}
+	config.SoftwareGroupNodes = map[string][]string{
+		"Bootnodes":         {"node1"},
+		"Quorum-Makers":     {"node2"},
+		"Quorum-Validators": {"node3"},
+		"VaultServers":      {"node4"},
+	}
+	return
+}
+
+func TestUpgradeConfig_GetGroupExecutionOrder(t *testing.T) {
+	config := newGroupOrderConfig()
+	for i := 0; i < 10; i++ { // map iteration order must not change the result
+		order, err := config.GetGroupExecutionOrder()
+		if err != nil {
+			t.Fatalf("GetGroupExecutionOrder failed: %v", err)
+		}
+		if fmt.Sprint(order) != "[Bootnodes Quorum-Makers Quorum-Validators VaultServers]" {
+			t.Fatalf("Groups should be in alphabetical order, but are: %v", order)
+		}
+	}
+
+	config.Common.GroupOrder = []string{"VaultServers", "Bootnodes"}
+	order, err := config.GetGroupExecutionOrder()
+	if err != nil || fmt.Sprint(order) != "[VaultServers Bootnodes Quorum-Makers Quorum-Validators]" {
+		t.Fatalf("group_order isn't followed: %v %v", order, err)
+	}
+
+	config.Common.DependsOn = map[string][]string{
+		"VaultServers":  {"Quorum-Validators"},
+		"Quorum-Makers": {"Quorum-Validators", "Bootnodes"},
+	}
+	order, err = config.GetGroupExecutionOrder()
+	if err != nil {
+		t.Fatalf("GetGroupExecutionOrder failed: %v", err)
+	}
+	position := make(map[string]int)
+	for i, groupName := range order {
+		position[groupName] = i
+	}
+	if len(order) != 4 || position["Quorum-Validators"] > position["VaultServers"] ||
+		position["Quorum-Validators"] > position["Quorum-Makers"] || position["Bootnodes"] > position["Quorum-Makers"] {
+		t.Fatalf("depends_on isn't followed: %v", order)
+	}
+}
+
+func TestUpgradeConfig_GetGroupExecutionOrderErrors(t *testing.T) {
+	config := newGroupOrderConfig()
+	config.Common.DependsOn = map[string][]string{
+		"Bootnodes":         {"Quorum-Validators"},
+		"Quorum-Validators": {"Quorum-Makers"},
+		"Quorum-Makers":     {"Bootnodes"},
+	}
+	_, err := config.GetGroupExecutionOrder()
+	if err == nil || !strings.Contains(err.Error(), "Bootnodes, Quorum-Makers, Quorum-Validators depend on each other in a cycle") {
+		t.Fatalf("Dependency cycle should be rejected, but returned: %v", err)
+	}
+
+	config.Common.DependsOn = map[string][]string{"Bootnodes": {"Bootnodes", "Observers"}}
+	_, err = config.GetGroupExecutionOrder()
+	if err == nil || !strings.Contains(err.Error(), "depends on itself") || !strings.Contains(err.Error(), "unknown software group: Observers") {
+		t.Fatalf("Invalid dependencies should be rejected, but returned: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type (
+	// CommandRunner runs a command on a node and returns its output.
+	// SSHConfig implicitly implements this interface.
+	CommandRunner interface {
+		Run(cmd string) (string, error)
+	}
+
+	// NodeConnection runs commands on a node, and connects to addresses as seen from the node.
+	// SSHConfig implicitly implements this interface.
+	NodeConnection interface {
+		CommandRunner
+		Dialer
+	}
+
+	// HealthChecker runs a health check once, returning nil if the node is healthy.
+	// A HealthChecker may remember the results of the previous calls.
+	HealthChecker interface {
+		Check() error
+	}
+
+	// HealthCheck specifies a check that is run on a node after its software has been started,
+	// in order to determine whether the software is healthy.
+	HealthCheck struct {
+		Type        string   `json:"type"`         // either empty for a command, or geth to check the chain's liveness using JSON-RPC
+		Cmd         string   `json:"cmd"`          // command to run on the node
+		ExitCode    int      `json:"exit_code"`    // expected exit code of the command, defaults to 0
+		OutputRegex string   `json:"output_regex"` // if specified, the output of the command must match this regular expression
+		Interval    Duration `json:"interval"`     // the amount of time to wait between each attempt
+		Timeout     Duration `json:"timeout"`      // the amount of time to keep retrying before the node is considered unhealthy
+		RPCAddress  string   `json:"rpc_address"`  // for geth, the JSON-RPC address as seen from the node, defaults to 127.0.0.1:8545
+		MinPeers    int      `json:"min_peers"`    // for geth, the number of peers required, defaults to 1
+	}
+
+	// commandChecker runs the health check's command
+	commandChecker struct {
+		check  *HealthCheck
+		runner CommandRunner
+	}
+
+	// chainLivenessChecker requires the block height to increase, and the number of peers to reach the threshold.
+	chainLivenessChecker struct {
+		check       *HealthCheck
+		client      *GethRPCClient
+		startHeight uint64
+		started     bool
+	}
+)
+
+const (
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 1 * time.Minute
+	defaultGethRPCAddress      = "127.0.0.1:8545"
+	defaultGethMinPeers        = 1
+)
+
+// NewChecker creates the HealthChecker for this health check's type, which checks the node using the given connection.
+func (check *HealthCheck) NewChecker(conn NodeConnection) HealthChecker {
+	switch check.Type {
+	case CHealthCheckGeth:
+		{
+			client := NewGethRPCClient(check.GetRPCAddress(), conn, check.GetInterval())
+			return &chainLivenessChecker{check: check, client: client}
+		}
+	default:
+		{
+			return &commandChecker{check: check, runner: conn}
+		}
+	}
+}
+
+// String describes the health check for logging
+func (check *HealthCheck) String() string {
+	if check.Type == CHealthCheckGeth {
+		return fmt.Sprintf("geth JSON-RPC at %s", check.GetRPCAddress())
+	}
+	return check.Cmd
+}
+
+// Check runs the health check command once using the given runner.
+// Returns nil if the exit code and the output are as expected.
+func (check *HealthCheck) Check(runner CommandRunner) (err error) {
+	output, err := runner.Run(check.Cmd)
+	exitCode := 0
+	if err != nil {
+		exitError, ok := err.(*ssh.ExitError)
+		if !ok { // unable to run the command at all, eg, the connection failed
+			return err
+		}
+		exitCode = exitError.ExitStatus()
+	}
+	if exitCode != check.ExitCode {
+		return fmt.Errorf(`health check "%s" exited with %d, expected %d`, check.Cmd, exitCode, check.ExitCode)
+	}
+	if check.OutputRegex != "" {
+		var matched bool
+		if matched, err = regexp.MatchString(check.OutputRegex, output); err != nil {
+			return err
+		}
+		if !matched {
+			return fmt.Errorf(`health check "%s" output: "%s" doesn't match "%s"`, check.Cmd, output, check.OutputRegex)
+		}
+	}
+	return nil
+}
+
+// GetInterval returns the amount of time to wait between each attempt, or the default if it isn't specified.
+func (check *HealthCheck) GetInterval() time.Duration {
+	if check.Interval.Duration <= 0 {
+		return defaultHealthCheckInterval
+	}
+	return check.Interval.Duration
+}
+
+// GetTimeout returns the amount of time to keep retrying the health check, or the default if it isn't specified.
+func (check *HealthCheck) GetTimeout() time.Duration {
+	if check.Timeout.Duration <= 0 {
+		return defaultHealthCheckTimeout
+	}
+	return check.Timeout.Duration
+}
+
+// GetRPCAddress returns the JSON-RPC address of geth, or the default if it isn't specified.
+func (check *HealthCheck) GetRPCAddress() string {
+	if check.RPCAddress == "" {
+		return defaultGethRPCAddress
+	}
+	return check.RPCAddress
+}
+
+// GetMinPeers returns the number of peers geth must have, or the default if it isn't specified.
+func (check *HealthCheck) GetMinPeers() uint64 {
+	if check.MinPeers <= 0 {
+		return defaultGethMinPeers
+	}
+	return uint64(check.MinPeers)
+}
+
+func (checker *commandChecker) Check() error {
+	return checker.check.Check(checker.runner)
+}
+
+// Check passes once the block height is higher than the block height seen on the first call,
+// and the node has the required number of peers.
+func (checker *chainLivenessChecker) Check() (err error) {
+	height, err := checker.client.BlockNumber()
+	if err != nil {
+		return
+	}
+	if !checker.started {
+		checker.startHeight = height
+		checker.started = true
+	}
+	peerCount, err := checker.client.PeerCount()
+	if err != nil {
+		return
+	}
+	syncing, err := checker.client.Syncing()
+	if err != nil {
+		return
+	}
+	if height <= checker.startHeight || peerCount < checker.check.GetMinPeers() {
+		return fmt.Errorf("%s: block height: %d (started at %d), peers: %d (requires %d), syncing: %v",
+			checker.check, height, checker.startHeight, peerCount, checker.check.GetMinPeers(), syncing)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package softwareupgrade
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type (
+	testRunner struct {
+		output string
+		err    error
+	}
+)
+
+func (runner *testRunner) Run(cmd string) (string, error) {
+	return runner.output, runner.err
+}
+
+func TestHealthCheck_Check(t *testing.T) {
+	check := &HealthCheck{Cmd: "sudo supervisorctl status quorum", OutputRegex: "RUNNING"}
+	if err := check.Check(&testRunner{output: "quorum RUNNING pid 1234"}); err != nil {
+		t.Fatalf("Check should succeed, but returned: %v", err)
+	}
+	if err := check.Check(&testRunner{output: "quorum STOPPED"}); err == nil {
+		t.Fatal("Check should fail when the output doesn't match")
+	}
+	if err := check.Check(&testRunner{err: errors.New("connection refused")}); err == nil {
+		t.Fatal("Check should fail when the command can't be run")
+	}
+
+	check = &HealthCheck{Cmd: "false", ExitCode: 1}
+	if err := check.Check(&testRunner{}); err == nil {
+		t.Fatal("Check should fail when the exit code is 0 but 1 is expected")
+	}
+}
+
+func TestHealthCheck_Defaults(t *testing.T) {
+	check := &HealthCheck{}
+	if check.GetInterval() != defaultHealthCheckInterval || check.GetTimeout() != defaultHealthCheckTimeout {
+		t.Fatal("Defaults should be used when interval and timeout aren't specified")
+	}
+	check.Interval.Duration = time.Second
+	check.Timeout.Duration = time.Minute * 5
+	if check.GetInterval() != time.Second || check.GetTimeout() != time.Minute*5 {
+		t.Fatal("Specified interval and timeout should be used")
+	}
+}
+
+type (
+	testNodeConnection struct {
+		testRunner
+		address string // address of the test server, used regardless of the address requested
+	}
+)
+
+func (conn *testNodeConnection) Dial(network, address string) (net.Conn, error) {
+	return net.Dial(network, conn.address)
+}
+
+func newTestGethServer(blockNumber *uint64, peerCount string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request gethRPCRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		var result string
+		switch request.Method {
+		case "eth_blockNumber":
+			result = fmt.Sprintf(`"0x%x"`, atomic.AddUint64(blockNumber, 1))
+		case "net_peerCount":
+			result = peerCount
+		case "eth_syncing":
+			result = "false"
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":%s}`, request.ID, result)
+	}))
+}
+
+func TestHealthCheck_GethChecker(t *testing.T) {
+	var blockNumber uint64 = 0x1b3
+	server := newTestGethServer(&blockNumber, `"0x3"`)
+	defer server.Close()
+	conn := &testNodeConnection{address: strings.TrimPrefix(server.URL, "http://")}
+
+	check := &HealthCheck{Type: CHealthCheckGeth, MinPeers: 3}
+	checker := check.NewChecker(conn)
+	if err := checker.Check(); err == nil {
+		t.Fatal("First check should fail as the block height hasn't increased yet")
+	}
+	if err := checker.Check(); err != nil {
+		t.Fatalf("Second check should pass as the block height increased, but returned: %v", err)
+	}
+
+	check = &HealthCheck{Type: CHealthCheckGeth, MinPeers: 4}
+	checker = check.NewChecker(conn)
+	checker.Check()
+	if err := checker.Check(); err == nil {
+		t.Fatal("Check should fail as there aren't enough peers")
+	}
+}
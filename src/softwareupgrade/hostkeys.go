@@ -0,0 +1,203 @@
+package softwareupgrade
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type (
+	// HostKeyPin is the result of pinning the host key of a node
+	HostKeyPin struct {
+		Node        string
+		KnownHosts  string // the known_hosts file the host key is pinned in
+		Fingerprint string
+		Status      string // pinned, known or changed
+		Err         error
+	}
+)
+
+// the status of a host key in a known_hosts file
+const (
+	hostKeyKnown   = "known"
+	hostKeyPinned  = "pinned"
+	hostKeyChanged = "changed"
+	hostKeyUnknown = "unknown"
+)
+
+var (
+	knownHostsMutex    sync.Mutex // serializes reading and adding to known_hosts files
+	errHostKeyCaptured = errors.New("host key captured")
+)
+
+// knownHostsFilename returns the expanded known_hosts filename, ~/.ssh/known_hosts if it's not specified
+func knownHostsFilename(filename string) string {
+	if filename == "" {
+		filename = CDefaultKnownHosts
+	}
+	if expandedFilename, err := Expand(filename); err == nil {
+		filename = expandedFilename
+	}
+	return filename
+}
+
+// hostKeyCallback returns the callback that checks the host key of the node according to the host key policy:
+// strict, the default, only accepts the keys in the known_hosts file, accept-new also accepts and adds the keys
+// of unknown hosts, and off accepts any key.
+func (sshConfig *SSHConfig) hostKeyCallback() ssh.HostKeyCallback {
+	if sshConfig.hostKeyPolicy == CHostKeyPolicyOff {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		acceptNew := sshConfig.hostKeyPolicy == CHostKeyPolicyAcceptNew
+		status, line, err := checkKnownHost(sshConfig.knownHosts, hostname, remote, key, acceptNew)
+		if err != nil {
+			return fmt.Errorf("unable to check the host key of node %s, error: %v", sshConfig.HostIPOrAddr, err)
+		}
+		switch status {
+		case hostKeyChanged:
+			{
+				return fmt.Errorf("the host key of node %s has changed, the node presented %s, but line %d of %s has a different key. "+
+					"Someone could be intercepting the connection. If the node's key was changed on purpose, remove the old key with ssh-keygen -R %s -f %s",
+					sshConfig.HostIPOrAddr, ssh.FingerprintSHA256(key), line, sshConfig.knownHosts, knownhosts.Normalize(hostname), sshConfig.knownHosts)
+			}
+		case hostKeyUnknown:
+			{
+				return fmt.Errorf("the host key %s of node %s is not in %s, pin it with PinHostKeys",
+					ssh.FingerprintSHA256(key), sshConfig.HostIPOrAddr, sshConfig.knownHosts)
+			}
+		case hostKeyPinned:
+			{
+				DebugLog.Println("Added the host key %s of node %s to %s", ssh.FingerprintSHA256(key), sshConfig.HostIPOrAddr, sshConfig.knownHosts)
+			}
+		}
+		return nil
+	}
+}
+
+// checkKnownHost looks the host key up in the known_hosts file, adding it if the host is unknown and addUnknown is true.
+// Returns the line of the known_hosts file with a different key when the key has changed.
+func checkKnownHost(filename, hostname string, remote net.Addr, key ssh.PublicKey, addUnknown bool) (status string, line int, err error) {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	status = hostKeyUnknown
+	if FileExists(filename) {
+		callback, err := knownhosts.New(filename)
+		if err != nil {
+			return "", 0, err
+		}
+		err = callback(hostname, remote, key)
+		if err == nil {
+			return hostKeyKnown, 0, nil
+		}
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return "", 0, err
+		}
+		if len(keyErr.Want) > 0 {
+			return hostKeyChanged, keyErr.Want[0].Line, nil
+		}
+	}
+
+	if addUnknown {
+		if err = addKnownHost(filename, hostname, key); err != nil {
+			return
+		}
+		status = hostKeyPinned
+	}
+	return
+}
+
+// addKnownHost appends the host key to the known_hosts file, creating the file if it doesn't exist
+func addKnownHost(filename, hostname string, key ssh.PublicKey) (err error) {
+	if err = os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return
+	}
+	data, _ := ReadDataFromFile(filename)
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	entry := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n"
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		entry = "\n" + entry
+	}
+	_, err = file.WriteString(entry)
+	return
+}
+
+// FetchHostKey connects to the node, through its jump hosts if any, and returns the host key that it presents,
+// without authenticating to the node.
+func FetchHostKey(sshInfo SSHInfo, hostIPOrAddr string) (key ssh.PublicKey, remote net.Addr, err error) {
+	target := &SSHConfig{HostIPOrAddr: hostIPOrAddr}
+	if count := len(sshInfo.SSHJumpHosts); count > 0 {
+		target.jumpHost = NewSSHConfigFromInfo(jumpHostInfo(sshInfo, count-1), sshInfo.SSHJumpHosts[count-1].Host)
+	}
+	config := &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remoteAddr net.Addr, hostKey ssh.PublicKey) error {
+			key, remote = hostKey, remoteAddr
+			return errHostKeyCaptured
+		},
+		Timeout: sshTimeout,
+	}
+	client, err := target.dialHost(config)
+	if client != nil {
+		client.Close()
+	}
+	if key != nil {
+		err = nil
+	}
+	return
+}
+
+// PinHostKeys adds the host key of every node, and of every jump host, to its known_hosts file,
+// unless the file already has a key for it. Jump hosts are pinned before the nodes behind them.
+// When knownHosts is specified, it's used instead of the ssh_known_hosts of each node.
+// Nodes whose key differs from the key in the known_hosts file are reported as changed, and their key isn't replaced.
+func (config *UpgradeConfig) PinHostKeys(knownHosts string) (result []HostKeyPin) {
+	pinned := make(map[string]bool)
+	pin := func(host string, sshInfo SSHInfo) {
+		if pinned[host] {
+			return
+		}
+		pinned[host] = true
+		result = append(result, pinHostKey(host, sshInfo))
+	}
+	for _, groupName := range config.GetGroupNames() {
+		for _, node := range config.GetGroupNodes(groupName) {
+			sshInfo := config.GetNodeSSHInfo(node)
+			if knownHosts != "" {
+				sshInfo.SSHKnownHosts = knownHosts // the jump hosts are checked against the given file too
+			}
+			for i, jumpHost := range sshInfo.SSHJumpHosts {
+				pin(jumpHost.Host, jumpHostInfo(sshInfo, i))
+			}
+			pin(node, sshInfo)
+		}
+	}
+	return
+}
+
+// pinHostKey adds the host key of the node to the known_hosts file, unless the file already has a key for the node
+func pinHostKey(node string, sshInfo SSHInfo) (result HostKeyPin) {
+	result = HostKeyPin{Node: node, KnownHosts: knownHostsFilename(sshInfo.SSHKnownHosts)}
+	key, remote, err := FetchHostKey(sshInfo, node)
+	if err == nil {
+		result.Fingerprint = ssh.FingerprintSHA256(key)
+		result.Status, _, err = checkKnownHost(result.KnownHosts, sshAddress(node), remote, key, true)
+	}
+	if err == nil && result.Status == hostKeyChanged {
+		err = fmt.Errorf("the host key of node %s differs from the key in %s, remove the old key with ssh-keygen -R %s -f %s to pin the new one",
+			node, result.KnownHosts, knownhosts.Normalize(sshAddress(node)), result.KnownHosts)
+	}
+	result.Err = err
+	return
+}
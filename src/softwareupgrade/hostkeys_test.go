@@ -0,0 +1,90 @@
+package softwareupgrade
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSSHConfig_HostKeyPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signer, key := newTestKey(t)
+	keyFilename := filepath.Join(dir, "id_rsa")
+	writeTestKey(t, keyFilename, key, "")
+	server := startTestSSHServer(t, signer.PublicKey())
+	defer server.close()
+	defer ClearSSHConfigCache()
+	knownHosts := filepath.Join(dir, "known_hosts")
+
+	run := func(user, policy string) error {
+		sshInfo := SSHInfo{SSHCert: keyFilename, SSHUserName: user, SSHKnownHosts: knownHosts, SSHHostKeyPolicy: policy}
+		_, err := NewSSHConfigFromInfo(sshInfo, server.address()).Run("true")
+		return err
+	}
+	if err = run("default", ""); err == nil || !strings.Contains(err.Error(), "is not in") {
+		t.Fatalf("The default policy should reject unknown hosts, but the error is %v", err)
+	}
+	if err = run("strict", CHostKeyPolicyStrict); err == nil || !strings.Contains(err.Error(), "is not in") {
+		t.Fatalf("strict should reject unknown hosts, but the error is %v", err)
+	}
+	if err = run("accept-new", CHostKeyPolicyAcceptNew); err != nil {
+		t.Fatalf("accept-new should accept unknown hosts, but the error is %v", err)
+	}
+	if err = run("pinned", CHostKeyPolicyStrict); err != nil {
+		t.Fatalf("strict should accept the key added by accept-new, but the error is %v", err)
+	}
+
+	otherSigner, _ := newTestKey(t)
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.address())}, otherSigner.PublicKey())
+	if err = ioutil.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = run("changed", CHostKeyPolicyAcceptNew); err == nil || !strings.Contains(err.Error(), "has changed") {
+		t.Fatalf("A changed key should be rejected, but the error is %v", err)
+	}
+	if err = run("off", CHostKeyPolicyOff); err != nil {
+		t.Fatalf("off should accept any key, but the error is %v", err)
+	}
+}
+
+func TestUpgradeConfig_PinHostKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signer, _ := newTestKey(t)
+	server := startTestSSHServer(t, signer.PublicKey())
+	defer server.close()
+	otherServer := startTestSSHServer(t, signer.PublicKey())
+	defer otherServer.close()
+
+	var config UpgradeConfig
+	config.Common.SoftwareGroup = map[string][]string{"Quorum-Validators": {"quorum"}}
+	config.SoftwareGroupNodes = map[string][]string{"Quorum-Validators": {server.address(), otherServer.address()}}
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(otherServer.address())}, signer.PublicKey())
+	if err = ioutil.WriteFile(knownHosts, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pins := config.PinHostKeys(knownHosts)
+	if len(pins) != 2 || pins[0].Status != hostKeyPinned || pins[0].Err != nil || pins[0].Fingerprint != ssh.FingerprintSHA256(server.hostKey) {
+		t.Fatalf("The key of the first node should be pinned, but the result is %+v", pins)
+	}
+	if pins[1].Status != hostKeyChanged || pins[1].Err == nil {
+		t.Fatalf("The key of the second node should be reported as changed, but the result is %+v", pins[1])
+	}
+	if pins = config.PinHostKeys(knownHosts); pins[0].Status != hostKeyKnown {
+		t.Fatalf("The key of the first node should already be pinned, but the result is %+v", pins[0])
+	}
+}
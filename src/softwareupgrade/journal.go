@@ -0,0 +1,194 @@
+package softwareupgrade
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+type (
+	// JournalEntry records the progress of upgrading a software on a node
+	JournalEntry struct {
+		Step    string                   `json:"Step"`              // the last step that was completed
+		Copy    map[string]UpgradeStruct `json:"Copy,omitempty"`    // the files being upgraded, including the permissions and owner before the upgrade
+		Running bool                     `json:"Running,omitempty"` // the software was started again before its upgrade was completed
+	}
+
+	// Journal records each step of the upgrade of every node's software as it's completed, so that an interrupted
+	// upgrade can be resumed from the exact step. It is saved to disk after each step, and is safe to be updated
+	// by multiple goroutines.
+	Journal struct {
+		SessionSuffix string                             `json:"SessionSuffix"`
+		Entries       map[string]map[string]JournalEntry `json:"Entries"` // node -> software -> progress
+		filename      string
+		mutex         sync.Mutex
+	}
+)
+
+// The steps of an upgrade, in the order they are completed
+const (
+	CStepStopped      string = "stopped"
+	CStepBackedUp     string = "backed-up"
+	CStepCopied       string = "copied"
+	CStepVerified     string = "verified"
+	CStepPostCommands string = "post-commands"
+	CStepStarted      string = "started"
+)
+
+var (
+	journalSteps = []string{"", CStepStopped, CStepBackedUp, CStepCopied, CStepVerified, CStepPostCommands, CStepStarted}
+)
+
+func journalStepIndex(step string) int {
+	for i := range journalSteps {
+		if journalSteps[i] == step {
+			return i
+		}
+	}
+	return 0
+}
+
+// NewJournal creates an empty journal that is saved to the given filename
+func NewJournal(filename, sessionSuffix string) *Journal {
+	return &Journal{
+		SessionSuffix: sessionSuffix,
+		Entries:       make(map[string]map[string]JournalEntry),
+		filename:      filename,
+	}
+}
+
+// LoadJournal loads the journal previously saved to the given filename
+func LoadJournal(filename string) (journal *Journal, err error) {
+	data, err := ReadDataFromFile(filename)
+	if err != nil {
+		return
+	}
+	journal = NewJournal(filename, "")
+	if err = json.Unmarshal(data, journal); err != nil {
+		return nil, err
+	}
+	if journal.Entries == nil {
+		journal.Entries = make(map[string]map[string]JournalEntry)
+	}
+	return
+}
+
+// Get returns the progress of upgrading the software on the node
+func (journal *Journal) Get(node, software string) (entry JournalEntry) {
+	if journal == nil {
+		return
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	return journal.Entries[node][software]
+}
+
+// Completed returns true if the given step, or a later step, has been completed for the software on the node
+func (journal *Journal) Completed(node, software, step string) bool {
+	return journalStepIndex(journal.Get(node, software).Step) >= journalStepIndex(step)
+}
+
+// Stopped returns true if the software on the node has been stopped by an interrupted upgrade, and hasn't been started again
+func (journal *Journal) Stopped(node, software string) bool {
+	entry := journal.Get(node, software)
+	return journalStepIndex(entry.Step) >= journalStepIndex(CStepStopped) && !entry.Running
+}
+
+// Record records that the given step has been completed for the software on the node, and saves the journal.
+func (journal *Journal) Record(node, software, step string) error {
+	return journal.update(node, software, func(entry *JournalEntry) {
+		entry.Step = step
+	})
+}
+
+// RecordStopped records that the software on the node has been stopped, and saves the journal.
+// The steps completed before the software was started again are kept, so that the upgrade continues after them.
+func (journal *Journal) RecordStopped(node, software string) error {
+	return journal.update(node, software, func(entry *JournalEntry) {
+		entry.Running = false
+		if journalStepIndex(entry.Step) < journalStepIndex(CStepStopped) {
+			entry.Step = CStepStopped
+		}
+	})
+}
+
+// RecordRunning records that the software on the node has been started again before its upgrade was completed,
+// and saves the journal. The software is then stopped again before the upgrade is resumed.
+func (journal *Journal) RecordRunning(node, software string) error {
+	return journal.update(node, software, func(entry *JournalEntry) {
+		entry.Running = true
+	})
+}
+
+// Remove removes the progress of upgrading the software on the node, and saves the journal
+func (journal *Journal) Remove(node, software string) error {
+	if journal == nil {
+		return nil
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	delete(journal.Entries[node], software)
+	if len(journal.Entries[node]) == 0 {
+		delete(journal.Entries, node)
+	}
+	return journal.save()
+}
+
+// RecordCopy records the files being upgraded for the software on the node, and saves the journal.
+func (journal *Journal) RecordCopy(node, software string, copyInfo map[string]UpgradeStruct) error {
+	return journal.update(node, software, func(entry *JournalEntry) {
+		entry.Copy = make(map[string]UpgradeStruct, len(copyInfo))
+		for k, v := range copyInfo {
+			entry.Copy[k] = v
+		}
+	})
+}
+
+func (journal *Journal) update(node, software string, updateFunc func(entry *JournalEntry)) error {
+	if journal == nil {
+		return nil
+	}
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+	nodeEntries := journal.Entries[node]
+	if nodeEntries == nil {
+		nodeEntries = make(map[string]JournalEntry)
+		journal.Entries[node] = nodeEntries
+	}
+	entry := nodeEntries[software]
+	updateFunc(&entry)
+	nodeEntries[software] = entry
+	return journal.save()
+}
+
+// save writes the journal to a temporary file, then renames it over the journal file,
+// so that the journal file is never left partially written. The caller must hold the mutex.
+func (journal *Journal) save() (err error) {
+	if journal.filename == "" {
+		return
+	}
+	filename, err := Expand(journal.filename)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return
+	}
+	tempFilename := filename + ".tmp"
+	file, err := os.OpenFile(tempFilename, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFilename)
+		return
+	}
+	return os.Rename(tempFilename, filename)
+}
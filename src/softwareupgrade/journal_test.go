@@ -0,0 +1,177 @@
+package softwareupgrade
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJournal_RecordAndLoad(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	filename := filepath.Join(tempDir, "journal.session")
+
+	journal := NewJournal(filename, "2019-01-02T03-04-05Z")
+	if journal.Completed("node1", "geth", CStepStopped) {
+		t.Fatal("Nothing should be completed in a new journal")
+	}
+	copyInfo := map[string]UpgradeStruct{"0": {DestFilePath: "/usr/bin/geth", Permissions: "0755"}}
+	if err = journal.RecordCopy("node1", "geth", copyInfo); err != nil {
+		t.Fatal(err)
+	}
+	if err = journal.Record("node1", "geth", CStepCopied); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.SessionSuffix != "2019-01-02T03-04-05Z" {
+		t.Fatalf("Session suffix should be restored, but is %s", loaded.SessionSuffix)
+	}
+	if !loaded.Completed("node1", "geth", CStepBackedUp) || !loaded.Completed("node1", "geth", CStepCopied) {
+		t.Fatal("Steps up to copied should be completed")
+	}
+	if loaded.Completed("node1", "geth", CStepVerified) || loaded.Completed("node2", "geth", CStepStopped) {
+		t.Fatal("Steps after copied, or for other nodes, shouldn't be completed")
+	}
+	if loaded.Get("node1", "geth").Copy["0"].Permissions != "0755" {
+		t.Fatal("Copy information should be restored")
+	}
+	if _, err = os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("Temporary journal file should not be left behind")
+	}
+}
+
+func TestJournal_Running(t *testing.T) {
+	journal := NewJournal("", "suffix")
+	if err := journal.RecordStopped("node1", "geth"); err != nil {
+		t.Fatal(err)
+	}
+	journal.Record("node1", "geth", CStepBackedUp)
+	if !journal.Stopped("node1", "geth") {
+		t.Fatal("The software should be stopped")
+	}
+	journal.RecordRunning("node1", "geth")
+	if journal.Stopped("node1", "geth") {
+		t.Fatal("The software shouldn't be stopped once it's running again")
+	}
+	journal.RecordStopped("node1", "geth")
+	if entry := journal.Get("node1", "geth"); entry.Running || entry.Step != CStepBackedUp {
+		t.Fatalf("Stopping the software again should keep the step %s, but the entry is %+v", CStepBackedUp, entry)
+	}
+}
+
+func TestJournal_Remove(t *testing.T) {
+	journal := NewJournal("", "suffix")
+	journal.Record("node1", "geth", CStepBackedUp)
+	journal.Record("node1", "quorum", CStepStopped)
+	if err := journal.Remove("node1", "geth"); err != nil {
+		t.Fatal(err)
+	}
+	if journal.Completed("node1", "geth", CStepStopped) || !journal.Completed("node1", "quorum", CStepStopped) {
+		t.Fatal("Only the removed software should be forgotten")
+	}
+	journal.Remove("node1", "quorum")
+	if _, exists := journal.Entries["node1"]; exists {
+		t.Fatal("A node without software should be removed")
+	}
+}
+
+func TestJournal_Nil(t *testing.T) {
+	var journal *Journal
+	if err := journal.Record("node1", "geth", CStepStarted); err != nil {
+		t.Fatal(err)
+	}
+	if journal.Completed("node1", "geth", CStepStopped) {
+		t.Fatal("Nothing should be completed without a journal")
+	}
+}
+
+func TestNodeInfoContainer_RunJournaledUpgrade(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	signer, key := newTestKey(t)
+	keyFilename := filepath.Join(tempDir, "id_rsa")
+	writeTestKey(t, keyFilename, key, "")
+	sourceFilename := filepath.Join(tempDir, "geth")
+	if err = ioutil.WriteFile(sourceFilename, []byte("geth"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := startTestSSHServer(t, signer.PublicKey())
+	defer server.close()
+	defer ClearSSHConfigCache()
+
+	sshConfig := NewSSHConfigFromInfo(SSHInfo{SSHCert: keyFilename, SSHUserName: "ubuntu", SSHHostKeyPolicy: CHostKeyPolicyOff}, server.address())
+	newNodeInfo := func() *NodeInfoContainer {
+		return &NodeInfoContainer{UpgradeInfo: UpgradeInfo{Copy: map[string]UpgradeStruct{"1": {SourceFilePath: sourceFilename,
+			DestFilePath: "/usr/bin/geth", Permissions: "0755", UserGroup: "root:root", BackupStrategy: "copy", VerifyCopy: "sha256"}}}}
+	}
+
+	// the remote file is only replaced once it's been backed up
+	server.failing = "sudo cp"
+	journal := NewJournal(filepath.Join(tempDir, "journal.session"), "suffix")
+	if err = newNodeInfo().RunJournaledUpgrade(sshConfig, journal, "node1", "geth"); err == nil {
+		t.Fatal("A failed backup should fail the upgrade")
+	}
+	if journal.Completed("node1", "geth", CStepBackedUp) {
+		t.Fatal("A failed backup shouldn't be recorded as backed up")
+	}
+
+	// the test server's sha256sum never matches, so the files must be copied again when resuming
+	server.failing = ""
+	if err = newNodeInfo().RunJournaledUpgrade(sshConfig, journal, "node1", "geth"); err == nil {
+		t.Fatal("A failed verification should fail the upgrade")
+	}
+	if step := journal.Get("node1", "geth").Step; step != CStepBackedUp {
+		t.Fatalf("A failed verification should leave the step at %s, but it's %s", CStepBackedUp, step)
+	}
+
+	journal = NewJournal(filepath.Join(tempDir, "missing", "journal.session"), "suffix")
+	if err = newNodeInfo().RunJournaledUpgrade(sshConfig, journal, "node1", "geth"); err == nil || !strings.Contains(err.Error(), "journal") {
+		t.Fatalf("A journal that can't be written should fail the upgrade, but the error is %v", err)
+	}
+}
+
+func TestNodeInfoContainer_RunJournaledUpgradeExecOnly(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	signer, key := newTestKey(t)
+	keyFilename := filepath.Join(tempDir, "id_rsa")
+	writeTestKey(t, keyFilename, key, "")
+	server := startTestSSHServer(t, signer.PublicKey())
+	defer server.close()
+	defer ClearSSHConfigCache()
+
+	sshConfig := NewSSHConfigFromInfo(SSHInfo{SSHCert: keyFilename, SSHUserName: "ubuntu", SSHHostKeyPolicy: CHostKeyPolicyOff}, server.address())
+	nodeInfo := &NodeInfoContainer{UpgradeInfo: UpgradeInfo{Exec: []string{"sudo systemctl daemon-reload"}}}
+	journal := NewJournal(filepath.Join(tempDir, "journal.session"), "suffix")
+	if err = nodeInfo.RunJournaledUpgrade(sshConfig, journal, "node1", "geth"); err != nil {
+		t.Fatal(err)
+	}
+	if commands := server.ran(); len(commands) != 1 || commands[0] != "sudo systemctl daemon-reload" {
+		t.Fatalf("Only the Exec command should be run, but the commands are %v", commands)
+	}
+	if step := journal.Get("node1", "geth").Step; step != CStepPostCommands {
+		t.Fatalf("The post-commands step should be recorded, but the step is %s", step)
+	}
+
+	if err = nodeInfo.RunUpgrade(sshConfig); err != nil {
+		t.Fatal(err)
+	}
+	if commands := server.ran(); len(commands) != 2 {
+		t.Fatalf("The Exec command should be run again without a journal, but the commands are %v", commands)
+	}
+}
@@ -0,0 +1,130 @@
+package softwareupgrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// configLoader merges configuration files into a single configuration
+	configLoader struct {
+		merged  map[string]interface{}
+		loading map[string]bool // the files being loaded, to detect include cycles
+	}
+)
+
+// LoadConfigFiles reads the configuration files in the given order, and merges them into a single configuration
+// returned as JSON. Each file is read as JSON, YAML or TOML according to its extension.
+// The files listed in the include key of a file are merged before that file, in the order listed, and are relative
+// to the directory of the file that includes them. Each file merged overrides the files merged before it:
+// objects, eg, software, common, nodes and groupnodes, are merged key by key, while the other values,
+// including arrays, are replaced. A null value removes the key, eg, a software defined by an earlier file.
+func LoadConfigFiles(filenames []string) (result []byte, err error) {
+	loader := &configLoader{merged: make(map[string]interface{}), loading: make(map[string]bool)}
+	for _, filename := range filenames {
+		if err = loader.load(filename); err != nil {
+			return
+		}
+	}
+	return json.Marshal(loader.merged)
+}
+
+// load merges the files included by the file, and then the file itself
+func (loader *configLoader) load(filename string) (err error) {
+	if expandedFilename, err := Expand(filename); err == nil {
+		filename = expandedFilename
+	}
+	if absFilename, err := filepath.Abs(filename); err == nil {
+		filename = absFilename
+	}
+	if loader.loading[filename] {
+		return fmt.Errorf("Configuration file %s includes itself", filename)
+	}
+	loader.loading[filename] = true
+	defer delete(loader.loading, filename)
+
+	format, err := GetConfigFormat(filename)
+	if err != nil {
+		return
+	}
+	data, err := ReadDataFromFile(filename)
+	if err != nil {
+		return
+	}
+	value, err := decodeConfigValue(data, format)
+	if err != nil {
+		if format == CConfigFormatJSON {
+			return fmt.Errorf("Unable to parse %s as JSON, error: %s", filename, describeJSONError(data, err))
+		}
+		return fmt.Errorf("Unable to parse %s as %s, error: %v", filename, strings.ToUpper(format), err)
+	}
+	document, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Configuration file %s must contain an object", filename)
+	}
+
+	includes, err := configIncludes(document[CConfigInclude])
+	if err != nil {
+		return fmt.Errorf("Configuration file %s: %v", filename, err)
+	}
+	delete(document, CConfigInclude)
+	for _, include := range includes {
+		if expandedInclude, err := Expand(include); err == nil {
+			include = expandedInclude
+		}
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(filename), include)
+		}
+		if err = loader.load(include); err != nil {
+			return
+		}
+	}
+	mergeConfigValues(loader.merged, document)
+	return
+}
+
+// configIncludes returns the filenames listed by the include key, which is either a string or an array of strings
+func configIncludes(value interface{}) (result []string, err error) {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		{
+			result = []string{v}
+		}
+	case []interface{}:
+		{
+			for i := range v {
+				filename, ok := v[i].(string)
+				if !ok {
+					return nil, fmt.Errorf("%s[%d] must be a filename", CConfigInclude, i)
+				}
+				result = append(result, filename)
+			}
+		}
+	default:
+		{
+			err = fmt.Errorf("%s must be a filename or an array of filenames", CConfigInclude)
+		}
+	}
+	return
+}
+
+// mergeConfigValues merges the override into the target, key by key for objects, other values are replaced,
+// and null values remove their keys
+func mergeConfigValues(target, override map[string]interface{}) {
+	for key, value := range override {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		overrideObject, overrideIsObject := value.(map[string]interface{})
+		targetObject, targetIsObject := target[key].(map[string]interface{})
+		if overrideIsObject && targetIsObject {
+			mergeConfigValues(targetObject, overrideObject)
+			continue
+		}
+		target[key] = value
+	}
+}
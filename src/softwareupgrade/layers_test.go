@@ -0,0 +1,87 @@
+package softwareupgrade
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeConfigFiles(t *testing.T, files map[string]string) (dir string) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for filename, contents := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, filename), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+func TestLoadConfigFiles(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"software.yaml": `
+common:
+  ssh_username: ubuntu
+  ssh_cert: ~/.ssh/quorum
+  software_group:
+    Quorum-Validators: [quorum]
+software:
+  vault:
+    start: sudo supervisorctl start vault
+  quorum:
+    start: sudo supervisorctl start quorum
+    stop: sudo supervisorctl stop quorum
+`,
+		"testnet.json": `{
+			"include": ["software.yaml"],
+			"common": {"ssh_cert": "~/.ssh/testnet"},
+			"software": {"quorum": {"stop": "sudo supervisorctl stop quorum && sleep 5"}, "vault": null},
+			"groupnodes": {"Quorum-Validators": ["node1", "node2"], "Quorum-Makers": ["node3"]}
+		}`,
+		"override.toml": `
+[groupnodes]
+Quorum-Validators = ["node4"]
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	data, err := LoadConfigFiles([]string{filepath.Join(dir, "testnet.json"), filepath.Join(dir, "override.toml")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config UpgradeConfig
+	if err = json.Unmarshal(data, &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Common.SSHUserName != "ubuntu" || config.Common.SSHCert != "~/.ssh/testnet" {
+		t.Fatalf("common should be merged key by key, but is %s", data)
+	}
+	quorum := config.Software["quorum"]
+	if quorum.StartCmd != "sudo supervisorctl start quorum" || quorum.StopCmd != "sudo supervisorctl stop quorum && sleep 5" {
+		t.Fatalf("software should be merged key by key, but is %s", data)
+	}
+	if _, ok := config.Software["vault"]; ok {
+		t.Fatalf("null should remove the software, but software is %s", data)
+	}
+	expected := map[string][]string{"Quorum-Validators": {"node4"}, "Quorum-Makers": {"node3"}}
+	if !reflect.DeepEqual(config.SoftwareGroupNodes, expected) {
+		t.Fatalf("Later files should replace the node lists, but groupnodes is %v", config.SoftwareGroupNodes)
+	}
+}
+
+func TestLoadConfigFiles_IncludeCycle(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"a.json": `{"include": "b.json"}`,
+		"b.json": `{"include": ["a.json"]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	if _, err := LoadConfigFiles([]string{filepath.Join(dir, "a.json")}); err == nil {
+		t.Fatal("Files that include each other should be rejected")
+	}
+}
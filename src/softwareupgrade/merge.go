@@ -0,0 +1,86 @@
+package softwareupgrade
+
+// mergeUpgradeInfo merges each override, in order, into the base field by field.
+// Empty fields of an override keep the value of the previous layer, lists such as the commands are replaced as a whole,
+// and Copy entries are merged key by key. The Copy map of the result is never shared with the layers.
+func mergeUpgradeInfo(base UpgradeInfo, overrides ...UpgradeInfo) (result UpgradeInfo) {
+	result = base
+	result.Copy = mergeCopy(nil, base.Copy)
+	for _, override := range overrides {
+		if len(override.PostUpgrade) > 0 {
+			result.PostUpgrade = override.PostUpgrade
+		}
+		if len(override.PreUpgrade) > 0 {
+			result.PreUpgrade = override.PreUpgrade
+		}
+		if override.StartCmd != "" {
+			result.StartCmd = override.StartCmd
+		}
+		if override.StopCmd != "" {
+			result.StopCmd = override.StopCmd
+		}
+		if len(override.Exec) > 0 {
+			result.Exec = override.Exec
+		}
+		if len(override.HealthCheck) > 0 {
+			result.HealthCheck = override.HealthCheck
+		}
+		if override.OnFailure != "" {
+			result.OnFailure = override.OnFailure
+		}
+		if override.VersionCmd != "" {
+			result.VersionCmd = override.VersionCmd
+		}
+		if override.VersionRegex != "" {
+			result.VersionRegex = override.VersionRegex
+		}
+		if override.TargetVersion != "" {
+			result.TargetVersion = override.TargetVersion
+		}
+		result.Copy = mergeCopy(result.Copy, override.Copy)
+	}
+	return
+}
+
+// mergeCopy merges the override Copy entries into the base Copy entries key by key, returning a new map
+func mergeCopy(base, override map[string]UpgradeStruct) (result map[string]UpgradeStruct) {
+	if base == nil && override == nil {
+		return
+	}
+	result = make(map[string]UpgradeStruct, len(base)+len(override))
+	for k, upgradeStruct := range base {
+		result[k] = upgradeStruct
+	}
+	for k, upgradeStruct := range override {
+		result[k] = mergeUpgradeStruct(result[k], upgradeStruct)
+	}
+	return
+}
+
+// mergeUpgradeStruct merges the override into the base field by field, empty fields of the override keep the value of the base.
+// Delete and Template are only kept when the override doesn't specify them, so that an override can turn them off.
+func mergeUpgradeStruct(base, override UpgradeStruct) (result UpgradeStruct) {
+	result = base
+	mergeString := func(target *string, value string) {
+		if value != "" {
+			*target = value
+		}
+	}
+	mergeString(&result.SourceFilePath, override.SourceFilePath)
+	mergeString(&result.DestFilePath, override.DestFilePath)
+	mergeString(&result.UserGroup, override.UserGroup)
+	mergeString(&result.Permissions, override.Permissions)
+	mergeString(&result.VerifyCopy, override.VerifyCopy)
+	mergeString(&result.RollbackPath, override.RollbackPath)
+	mergeString(&result.BackupStrategy, override.BackupStrategy)
+	mergeString(&result.Sha256, override.Sha256)
+	mergeString(&result.Type, override.Type)
+	mergeBool := func(target **bool, value *bool) {
+		if value != nil {
+			*target = value
+		}
+	}
+	mergeBool(&result.Delete, override.Delete)
+	mergeBool(&result.Template, override.Template)
+	return
+}
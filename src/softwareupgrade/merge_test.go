@@ -0,0 +1,69 @@
+package softwareupgrade
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpgradeConfig_GetNodeUpgradeInfoMerge(t *testing.T) {
+	enabled, disabled := true, false
+	var config UpgradeConfig
+	config.Common.SoftwareGroup = map[string][]string{"Quorum-Validators": {"quorum"}, "Quorum-Makers": {"quorum"}}
+	config.SoftwareGroupNodes = map[string][]string{"Quorum-Validators": {"node1", "node2"}, "Quorum-Makers": {"node3"}}
+	config.Software = map[string]UpgradeInfo{
+		"quorum": {
+			StartCmd: "sudo supervisorctl start quorum",
+			StopCmd:  "sudo supervisorctl stop quorum",
+			Exec:     []string{"echo done"},
+			Copy: map[string]UpgradeStruct{
+				"1": {SourceFilePath: "/tmp/geth", DestFilePath: "/usr/local/bin/geth", Permissions: "0755"},
+				"2": {SourceFilePath: "/tmp/quorum.conf", DestFilePath: "/etc/supervisor/conf.d/quorum.conf", Permissions: "0644", Template: &enabled},
+			},
+		},
+	}
+	config.GroupOverrides = map[string]map[string]UpgradeInfo{
+		"Quorum-Validators": {"quorum": {StopCmd: "sudo supervisorctl stop quorum && sleep 30", OnFailure: COnFailureRollback,
+			VersionCmd: "geth version", TargetVersion: "2.2.0"}},
+	}
+	config.Nodes = map[string]NodeInfoContainer{
+		"node2": {UpgradeInfo: UpgradeInfo{
+			StartCmd: "sudo supervisorctl start quorum-node2",
+			Copy:     map[string]UpgradeStruct{"2": {Permissions: "0600", Template: &disabled}},
+		}},
+	}
+
+	node1 := config.GetNodeUpgradeInfo("node1", "quorum")
+	node2 := config.GetNodeUpgradeInfo("node2", "quorum")
+	node3 := config.GetNodeUpgradeInfo("node3", "quorum")
+	if node1.StopCmd != "sudo supervisorctl stop quorum && sleep 30" || node2.StopCmd != node1.StopCmd {
+		t.Fatalf("Group override should apply to all the validators, but stop commands are %q and %q", node1.StopCmd, node2.StopCmd)
+	}
+	if node3.StopCmd != "sudo supervisorctl stop quorum" {
+		t.Fatalf("Group override shouldn't apply to other groups, but stop command is %q", node3.StopCmd)
+	}
+	if node1.OnFailure != COnFailureRollback || node1.VersionCmd != "geth version" || node2.TargetVersion != "2.2.0" || node3.OnFailure != "" {
+		t.Fatalf("Group overrides of on_failure and the version should apply to the validators, got %+v", node1.UpgradeInfo)
+	}
+	if node2.StartCmd != "sudo supervisorctl start quorum-node2" || node1.StartCmd != "sudo supervisorctl start quorum" {
+		t.Fatal("Node override should only apply to the node")
+	}
+
+	// overriding one field of one file keeps everything else
+	expected := UpgradeStruct{SourceFilePath: "/tmp/quorum.conf", DestFilePath: "/etc/supervisor/conf.d/quorum.conf",
+		Permissions: "0600", BackupStrategy: "copy", VerifyCopy: "sha256", Template: &disabled}
+	if !reflect.DeepEqual(node2.Copy["2"], expected) {
+		t.Fatalf("Copy entries should be merged, expected %+v, but got %+v", expected, node2.Copy["2"])
+	}
+	if !node1.Copy["2"].IsTemplate() || node2.Copy["2"].IsTemplate() {
+		t.Fatal("An override should be able to turn Template off")
+	}
+	if len(node2.Copy) != 2 || node2.Copy["1"].DestFilePath != "/usr/local/bin/geth" {
+		t.Fatalf("Copy entries that aren't overridden should be kept: %+v", node2.Copy)
+	}
+	if !reflect.DeepEqual(node2.Exec, []string{"echo done"}) {
+		t.Fatalf("Exec shouldn't be replaced by a node's Copy, but is %v", node2.Exec)
+	}
+	if config.Software["quorum"].Copy["2"].Permissions != "0644" {
+		t.Fatal("Merging must not change the configuration")
+	}
+}
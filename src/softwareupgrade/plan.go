@@ -0,0 +1,154 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+type (
+	// RemoteInspector specifies the read-only functions used to inspect a node when planning an upgrade
+	RemoteInspector interface {
+		DirectoryExists(path string) (result bool, err error)
+		FileExists(file string) (result bool, err error)
+		Sha256sum(path string) (result string, err error)
+	}
+
+	// FilePlan describes what would happen to a file when a software is upgraded
+	FilePlan struct {
+		LocalFilename  string `json:"local_filename"`
+		RemoteFilename string `json:"remote_filename"`
+		LocalSha256    string `json:"local_sha256"`
+		RemoteSha256   string `json:"remote_sha256,omitempty"` // empty if the remote file doesn't exist
+		Changed        bool   `json:"changed"`                 // true if the remote file differs from the local file
+		Rendered       string `json:"rendered,omitempty"`      // the contents of the file rendered for the node, if it's a template
+	}
+
+	// SoftwarePlan describes what would happen when a software is upgraded on a node
+	SoftwarePlan struct {
+		Node               string     `json:"node"`
+		Software           string     `json:"software"`
+		Files              []FilePlan `json:"files"`
+		Backups            []string   `json:"backups"`             // the backup commands that would run
+		MissingDirectories []string   `json:"missing_directories"` // the remote directories that don't exist
+		Commands           []string   `json:"commands"`            // the commands that would run, in order
+		Error              string     `json:"error,omitempty"`     // the error encountered while inspecting the node
+	}
+
+	// UpgradePlan describes what would happen for every node and software in an upgrade
+	UpgradePlan struct {
+		Software []SoftwarePlan `json:"software"`
+	}
+)
+
+// Plan inspects the node without changing anything, and describes what upgrading the software would do.
+func (nodeInfo *NodeInfoContainer) Plan(remote RemoteInspector, local Hasher, node, software string) (result SoftwarePlan) {
+	var msg string
+	result.Node = node
+	result.Software = software
+	dirExists := make(map[string]bool)
+	for _, index := range nodeInfo.copyIndexes() {
+		upgradeStruct := nodeInfo.Copy[index]
+		filePlan := FilePlan{
+			LocalFilename:  upgradeStruct.SourceFilePath,
+			RemoteFilename: upgradeStruct.DestFilePath,
+		}
+		var err error
+		if upgradeStruct.IsTemplate() {
+			var rendered []byte
+			if rendered, err = nodeInfo.RenderTemplate(upgradeStruct.SourceFilePath); err != nil {
+				msg = fmt.Sprintf("%sUnable to render %s, error: %v\n", msg, upgradeStruct.SourceFilePath, err)
+			}
+			filePlan.Rendered = string(rendered)
+		}
+		if upgradeStruct.Type != CCopyTypeDirectory && err == nil {
+			if filePlan.LocalSha256, err = nodeInfo.sourceHasher(upgradeStruct, local).Sha256sum(upgradeStruct.SourceFilePath); err != nil {
+				msg = fmt.Sprintf("%sUnable to hash %s, error: %v\n", msg, upgradeStruct.SourceFilePath, err)
+			}
+		}
+
+		remoteDir := path.Dir(upgradeStruct.DestFilePath)
+		exists, checked := dirExists[remoteDir]
+		if !checked {
+			if exists, err = remote.DirectoryExists(remoteDir); err != nil {
+				msg = fmt.Sprintf("%sUnable to check directory %s, error: %v\n", msg, remoteDir, err)
+			}
+			dirExists[remoteDir] = exists
+			if !exists && err == nil {
+				result.MissingDirectories = append(result.MissingDirectories, remoteDir)
+			}
+		}
+
+		var fileExists bool
+		if exists {
+			if fileExists, err = remote.FileExists(upgradeStruct.DestFilePath); err != nil {
+				msg = fmt.Sprintf("%sUnable to check file %s, error: %v\n", msg, upgradeStruct.DestFilePath, err)
+			}
+		}
+		if fileExists {
+			// the contents of a directory can't be compared, so a directory is always changed
+			if !upgradeStruct.IsDirectory() {
+				if filePlan.RemoteSha256, err = remote.Sha256sum(upgradeStruct.DestFilePath); err != nil {
+					msg = fmt.Sprintf("%sUnable to hash %s, error: %v\n", msg, upgradeStruct.DestFilePath, err)
+				}
+			}
+			if cmd := upgradeStruct.backupCommand(backupSuffix); cmd != "" {
+				result.Backups = append(result.Backups, cmd)
+			}
+		}
+		filePlan.Changed = filePlan.RemoteSha256 == "" || filePlan.RemoteSha256 != filePlan.LocalSha256
+		result.Files = append(result.Files, filePlan)
+	}
+
+	if nodeInfo.StopCmd != "" {
+		result.Commands = append(result.Commands, nodeInfo.StopCmd)
+	}
+	result.Commands = append(result.Commands, nodeInfo.PreUpgrade...)
+	result.Commands = append(result.Commands, nodeInfo.PostUpgrade...)
+	result.Commands = append(result.Commands, nodeInfo.Exec...)
+	if nodeInfo.StartCmd != "" {
+		result.Commands = append(result.Commands, nodeInfo.StartCmd)
+	}
+	result.Error = strings.TrimSpace(msg)
+	return
+}
+
+// WriteText writes the plan in a human readable form
+func (plan *UpgradePlan) WriteText(w io.Writer) (err error) {
+	for _, softwarePlan := range plan.Software {
+		text := fmt.Sprintf("Node: %s, software: %s\n", softwarePlan.Node, softwarePlan.Software)
+		if softwarePlan.Error != "" {
+			text += fmt.Sprintf("  Error: %s\n", softwarePlan.Error)
+		}
+		for _, filePlan := range softwarePlan.Files {
+			status := "unchanged"
+			if filePlan.Changed {
+				status = "changed"
+			}
+			text += fmt.Sprintf("  File: %s -> %s (%s)\n", filePlan.LocalFilename, filePlan.RemoteFilename, status)
+			if filePlan.Rendered != "" {
+				text += "    Rendered:\n" + indent(filePlan.Rendered, "      ")
+			}
+		}
+		for _, dir := range softwarePlan.MissingDirectories {
+			text += fmt.Sprintf("  Missing directory: %s\n", dir)
+		}
+		for _, backup := range softwarePlan.Backups {
+			text += fmt.Sprintf("  Backup: %s\n", backup)
+		}
+		for _, cmd := range softwarePlan.Commands {
+			text += fmt.Sprintf("  Command: %s\n", cmd)
+		}
+		if _, err = io.WriteString(w, text); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// indent prefixes each line of the text, ensuring it ends with a newline
+func indent(text, prefix string) string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	return prefix + strings.Join(lines, "\n"+prefix) + "\n"
+}
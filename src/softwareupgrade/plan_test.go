@@ -0,0 +1,84 @@
+package softwareupgrade
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type (
+	testInspector struct {
+		dirs   map[string]bool
+		hashes map[string]string // remote file -> sha256
+	}
+
+	testHasher map[string]string // local file -> sha256
+)
+
+func (inspector *testInspector) DirectoryExists(path string) (bool, error) {
+	return inspector.dirs[path], nil
+}
+
+func (inspector *testInspector) FileExists(file string) (bool, error) {
+	_, ok := inspector.hashes[file]
+	return ok, nil
+}
+
+func (inspector *testInspector) Sha256sum(path string) (string, error) {
+	return inspector.hashes[path], nil
+}
+
+func (hasher testHasher) Md5sum(path string) (string, error) {
+	return "", nil
+}
+
+func (hasher testHasher) Sha256sum(path string) (string, error) {
+	return hasher[path], nil
+}
+
+func TestNodeInfoContainer_Plan(t *testing.T) {
+	nodeInfo := NodeInfoContainer{}
+	nodeInfo.StopCmd = "sudo supervisorctl stop quorum"
+	nodeInfo.StartCmd = "sudo supervisorctl start quorum"
+	nodeInfo.PreUpgrade = []string{"echo pre"}
+	nodeInfo.Copy = map[string]UpgradeStruct{
+		"1": {SourceFilePath: "geth", DestFilePath: "/usr/local/bin/geth", BackupStrategy: "copy"},
+		"2": {SourceFilePath: "bootnode", DestFilePath: "/usr/local/bin/bootnode", BackupStrategy: "move"},
+		"3": {SourceFilePath: "vault", DestFilePath: "/opt/vault/bin/vault"},
+	}
+	inspector := &testInspector{
+		dirs:   map[string]bool{"/usr/local/bin": true},
+		hashes: map[string]string{"/usr/local/bin/geth": "aaaa", "/usr/local/bin/bootnode": "bbbb"},
+	}
+	hasher := testHasher{"geth": "aaaa", "bootnode": "cccc", "vault": "dddd"}
+
+	plan := nodeInfo.Plan(inspector, hasher, "node1", "quorum")
+	if len(plan.Files) != 3 {
+		t.Fatalf("Plan should have 3 files, but has %d", len(plan.Files))
+	}
+	if plan.Files[0].Changed || !plan.Files[1].Changed || !plan.Files[2].Changed {
+		t.Fatalf("Only geth should be unchanged, plan: %+v", plan.Files)
+	}
+	if len(plan.MissingDirectories) != 1 || plan.MissingDirectories[0] != "/opt/vault/bin" {
+		t.Fatalf("/opt/vault/bin should be missing, but missing directories are %v", plan.MissingDirectories)
+	}
+	if len(plan.Backups) != 2 || !strings.HasPrefix(plan.Backups[1], "sudo mv /usr/local/bin/bootnode ") {
+		t.Fatalf("Existing files should be backed up, but backups are %v", plan.Backups)
+	}
+	if len(plan.Commands) != 3 || plan.Commands[0] != nodeInfo.StopCmd || plan.Commands[2] != nodeInfo.StartCmd {
+		t.Fatalf("Commands should be stop, pre-upgrade, start, but are %v", plan.Commands)
+	}
+
+	upgradePlan := UpgradePlan{Software: []SoftwarePlan{plan}}
+	var text bytes.Buffer
+	if err := upgradePlan.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "Missing directory: /opt/vault/bin") {
+		t.Fatalf("Text plan doesn't contain the missing directory:\n%s", text.String())
+	}
+	if _, err := json.Marshal(upgradePlan); err != nil {
+		t.Fatal(err)
+	}
+}
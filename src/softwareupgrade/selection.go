@@ -0,0 +1,165 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type (
+	// Selection restricts the software groups, nodes and software that are processed.
+	// A nil Selection selects everything.
+	Selection struct {
+		groups, nodes, software, exclude []namePattern
+	}
+
+	// namePattern matches names with either a glob or a regular expression
+	namePattern struct {
+		glob  string
+		regex *regexp.Regexp
+	}
+)
+
+// NewSelection creates a selection from lists of patterns. A name is selected when it matches one of the patterns of
+// its kind, or when there's no pattern of its kind, and it doesn't match any of the exclude patterns.
+// Each value is either a comma separated list of globs, eg, validator-*,maker-1, or a regular expression
+// enclosed in slashes, eg, /^validator-[0-9]+$/.
+func NewSelection(groups, nodes, software, exclude []string) (result *Selection, err error) {
+	result = &Selection{}
+	for _, item := range []struct {
+		patterns *[]namePattern
+		values   []string
+		name     string
+	}{
+		{&result.groups, groups, "groups"},
+		{&result.nodes, nodes, "nodes"},
+		{&result.software, software, "software"},
+		{&result.exclude, exclude, "exclude"},
+	} {
+		if *item.patterns, err = parseNamePatterns(item.values); err != nil {
+			return nil, fmt.Errorf("Invalid %s selection, error: %v", item.name, err)
+		}
+	}
+	return
+}
+
+func parseNamePatterns(values []string) (result []namePattern, err error) {
+	for _, value := range values {
+		if len(value) > 1 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+			var regex *regexp.Regexp
+			if regex, err = regexp.Compile(value[1 : len(value)-1]); err != nil {
+				return
+			}
+			result = append(result, namePattern{regex: regex})
+			continue
+		}
+		for _, glob := range strings.Split(value, ",") {
+			glob = strings.TrimSpace(glob)
+			if glob == "" {
+				continue
+			}
+			if _, err = path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("%s: %v", glob, err)
+			}
+			result = append(result, namePattern{glob: glob})
+		}
+	}
+	return
+}
+
+func (pattern namePattern) match(name string) bool {
+	if pattern.regex != nil {
+		return pattern.regex.MatchString(name)
+	}
+	matched, _ := path.Match(pattern.glob, name)
+	return matched
+}
+
+func matchAny(patterns []namePattern, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects returns true if the name matches the patterns of its kind, and isn't excluded
+func (selection *Selection) selects(patterns []namePattern, name string) bool {
+	if selection == nil {
+		return true
+	}
+	return (len(patterns) == 0 || matchAny(patterns, name)) && !matchAny(selection.exclude, name)
+}
+
+// IsEmpty returns true if the selection selects everything
+func (selection *Selection) IsEmpty() bool {
+	return selection == nil ||
+		len(selection.groups)+len(selection.nodes)+len(selection.software)+len(selection.exclude) == 0
+}
+
+// SelectsGroup returns true if the software group is selected
+func (selection *Selection) SelectsGroup(groupName string) bool {
+	if selection == nil {
+		return true
+	}
+	return selection.selects(selection.groups, groupName)
+}
+
+// SelectNodes returns the selected nodes, in the same order
+func (selection *Selection) SelectNodes(nodes []string) (result []string) {
+	if selection == nil {
+		return nodes
+	}
+	for _, node := range nodes {
+		if selection.selects(selection.nodes, node) {
+			result = append(result, node)
+		}
+	}
+	return
+}
+
+// SelectSoftware returns the selected software, in the same order
+func (selection *Selection) SelectSoftware(software []string) (result []string) {
+	if selection == nil {
+		return software
+	}
+	for _, name := range software {
+		if selection.selects(selection.software, name) {
+			result = append(result, name)
+		}
+	}
+	return
+}
+
+// GetSelectedGroupNodes gets the selected nodes of the software group, none if the group isn't selected,
+// or if none of its software is selected.
+func (config *UpgradeConfig) GetSelectedGroupNodes(selection *Selection, groupName string) []string {
+	if len(config.GetSelectedGroupSoftware(selection, groupName)) == 0 {
+		return nil
+	}
+	return selection.SelectNodes(config.GetGroupNodes(groupName))
+}
+
+// GetSelectedGroupSoftware gets the selected software of the software group, none if the group isn't selected.
+func (config *UpgradeConfig) GetSelectedGroupSoftware(selection *Selection, groupName string) []string {
+	if !selection.SelectsGroup(groupName) {
+		return nil
+	}
+	return selection.SelectSoftware(config.GetGroupSoftware(groupName))
+}
+
+// GetSelectedNodes gets the nodes that have selected software in any of the given software groups, without duplicates.
+func (config *UpgradeConfig) GetSelectedNodes(selection *Selection, groupNames []string) (result []string) {
+	listed := make(map[string]bool)
+	for _, groupName := range groupNames {
+		for _, node := range config.GetSelectedGroupNodes(selection, groupName) {
+			if !listed[node] {
+				listed[node] = true
+				result = append(result, node)
+			}
+		}
+	}
+	return
+}
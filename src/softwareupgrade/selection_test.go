@@ -0,0 +1,55 @@
+package softwareupgrade
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUpgradeConfig_GetSelectedGroupNodes(t *testing.T) {
+	var config UpgradeConfig
+	config.Common.SoftwareGroup = map[string][]string{
+		"Quorum-Validators": {"consul", "crashquorum", "quorum"},
+		"VaultServers":      {"consul", "vault"},
+	}
+	config.SoftwareGroupNodes = map[string][]string{
+		"Quorum-Validators": {"validator-1", "validator-2", "validator-10"},
+		"VaultServers":      {"vault-1"},
+	}
+
+	selection, err := NewSelection([]string{"Quorum-*"}, []string{"/^validator-[0-9]$/"}, nil, []string{"crash*,validator-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes := config.GetSelectedGroupNodes(selection, "Quorum-Validators"); !reflect.DeepEqual(nodes, []string{"validator-1"}) {
+		t.Fatalf("Only validator-1 should be selected, but %v are", nodes)
+	}
+	if software := config.GetSelectedGroupSoftware(selection, "Quorum-Validators"); !reflect.DeepEqual(software, []string{"consul", "quorum"}) {
+		t.Fatalf("The crash software should be excluded, but %v are selected", software)
+	}
+	if nodes := config.GetSelectedGroupNodes(selection, "VaultServers"); len(nodes) != 0 {
+		t.Fatalf("Nodes of unselected groups shouldn't be selected, but %v are", nodes)
+	}
+
+	selection, err = NewSelection(nil, nil, []string{"vault"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupNames := []string{"Quorum-Validators", "VaultServers"}
+	if nodes := config.GetSelectedNodes(selection, groupNames); !reflect.DeepEqual(nodes, []string{"vault-1"}) {
+		t.Fatalf("Only the nodes of the groups with the selected software should be selected, but %v are", nodes)
+	}
+
+	var everything *Selection
+	if nodes := config.GetSelectedNodes(everything, groupNames); len(nodes) != 4 || !everything.IsEmpty() {
+		t.Fatalf("A nil selection should select every node, but %v are selected", nodes)
+	}
+}
+
+func TestNewSelection_Invalid(t *testing.T) {
+	if _, err := NewSelection(nil, []string{"/validator-[/"}, nil, nil); err == nil {
+		t.Fatal("Invalid regular expressions should be rejected")
+	}
+	if _, err := NewSelection(nil, nil, []string{"quorum,["}, nil); err == nil {
+		t.Fatal("Invalid globs should be rejected")
+	}
+}
@@ -18,3 +18,14 @@ func init() {
 func GetBackupSuffix() string {
 	return backupSuffix
 }
+
+// SetBackupSuffix sets the backup suffix, so that an interrupted session can be continued
+func SetBackupSuffix(suffix string) {
+	backupSuffix = suffix
+}
+
+// AddDecision records the choice made by the operator for the node or software group.
+// It's not safe to be called by multiple nodes at the same time.
+func (session *RollbackSession) AddDecision(mode, scope, name, choice string) {
+	session.Decisions = append(session.Decisions, OperatorDecision{time.Now().UTC(), mode, scope, name, choice})
+}
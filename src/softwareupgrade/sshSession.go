@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path"
 	"strings"
@@ -19,16 +20,21 @@ import (
 // pgrep and pkill is assumed to be located in the environmental PATH
 
 type (
-	// SSHConfig is used to carry the username, privatekey and the host to connect to.
+	// SSHConfig is used to carry the username, the authentication methods and the host to connect to.
 	SSHConfig struct {
 		user              string
-		privateKey        string
+		keyFilename       string
+		passphrase        string   // where the passphrase of encrypted keys is read from
+		auth              []string // the authentication methods tried in order
+		knownHosts        string   // the known_hosts file the host key is checked against
+		hostKeyPolicy     string
+		jumpHost          *SSHConfig // the last jump host the connection is tunneled through, if any
+		mutex             sync.Mutex // serializes connecting the client of jump hosts shared by several nodes
 		HostIPOrAddr      string
 		RemoteOS          string
 		session           *ssh.Session
 		client            *ssh.Client
 		autoOpenSession   bool
-		parsedKey         ssh.Signer
 		keepAliveDuration time.Duration
 	}
 
@@ -40,12 +46,20 @@ type (
 )
 
 var (
-	sshConfigCache map[string]*SSHConfig
-	sshTimeout     time.Duration
+	sshConfigCache      map[string]*SSHConfig
+	sshConfigCacheMutex sync.Mutex
+	sshTimeout          time.Duration
 )
 
 // EnsureSSHConfigCache initializes the sshConfigCache so it can be used to cache SSHConfig
 func EnsureSSHConfigCache() {
+	sshConfigCacheMutex.Lock()
+	defer sshConfigCacheMutex.Unlock()
+	ensureSSHConfigCache()
+}
+
+// ensureSSHConfigCache is EnsureSSHConfigCache without locking, the caller must hold sshConfigCacheMutex.
+func ensureSSHConfigCache() {
 	if sshConfigCache == nil {
 		sshConfigCache = make(map[string]*SSHConfig)
 	}
@@ -53,6 +67,8 @@ func EnsureSSHConfigCache() {
 
 // ClearSSHConfigCache closes the SSH session and client connection in the sshConfigCache
 func ClearSSHConfigCache() {
+	sshConfigCacheMutex.Lock()
+	defer sshConfigCacheMutex.Unlock()
 	if sshConfigCache != nil {
 		for k, v := range sshConfigCache {
 			v.Close()
@@ -60,40 +76,58 @@ func ClearSSHConfigCache() {
 			delete(sshConfigCache, k)
 		}
 	}
+	clearSSHKeyCache()
 }
 
 // NewSSHConfig initializes a SSHConfig structure for executing a Run or Copy* command.
 func NewSSHConfig(user, KeyFilename, HostIPOrAddr string) (result *SSHConfig) {
+	return NewSSHConfigFromInfo(SSHInfo{SSHCert: KeyFilename, SSHUserName: user}, HostIPOrAddr)
+}
+
+// NewSSHConfigFromInfo initializes a SSHConfig structure for executing a Run or Copy* command,
+// authenticating with the methods given in sshInfo.
+func NewSSHConfigFromInfo(sshInfo SSHInfo, HostIPOrAddr string) (result *SSHConfig) {
+	KeyFilename := sshInfo.SSHCert
 	if expandedKeyFilename, err := Expand(KeyFilename); err == nil {
 		KeyFilename = expandedKeyFilename
 	}
-	mapName := HostIPOrAddr + user + KeyFilename
+	user := sshInfo.SSHUserName
+	mapName := HostIPOrAddr + user + KeyFilename + sshInfo.SSHPassphrase + strings.Join(sshInfo.SSHAuth, ",") +
+		sshInfo.SSHKnownHosts + sshInfo.SSHHostKeyPolicy + fmt.Sprint(sshInfo.SSHJumpHosts)
+
+	// the jump hosts are cached too, so that their connection is shared by all the nodes behind them
+	var jumpHost *SSHConfig
+	if count := len(sshInfo.SSHJumpHosts); count > 0 {
+		jumpHost = NewSSHConfigFromInfo(jumpHostInfo(sshInfo, count-1), sshInfo.SSHJumpHosts[count-1].Host)
+	}
 
-	EnsureSSHConfigCache() // guard against forgetful devs!
+	sshConfigCacheMutex.Lock()
+	defer sshConfigCacheMutex.Unlock()
+	ensureSSHConfigCache() // guard against forgetful devs!
 	result = sshConfigCache[mapName]
 	if result != nil {
 		return
 	}
 
-	privateKey := []byte{}
-	var err error
-	privateKey, err = ReadDataFromFile(KeyFilename)
 	result = &SSHConfig{
 		user:              user,
+		keyFilename:       KeyFilename,
+		passphrase:        sshInfo.SSHPassphrase,
+		auth:              sshInfo.SSHAuth,
+		knownHosts:        knownHostsFilename(sshInfo.SSHKnownHosts),
+		hostKeyPolicy:     sshInfo.SSHHostKeyPolicy,
+		jumpHost:          jumpHost,
 		HostIPOrAddr:      HostIPOrAddr,
 		keepAliveDuration: 5 * time.Second,
 	}
-	if err == nil {
-		result.privateKey = string(privateKey)
-	}
 	result.EnableAutoOpen()
 	sshConfigCache[mapName] = result
 	return
 }
 
-// Clear clears the privateKey, user and host stored in the configuration.
+// Clear clears the key, user and host stored in the configuration.
 func (sshConfig *SSHConfig) Clear() {
-	sshConfig.privateKey = ""
+	sshConfig.keyFilename = ""
 	sshConfig.user = ""
 	sshConfig.HostIPOrAddr = ""
 }
@@ -126,16 +160,21 @@ func (sshConfig *SSHConfig) CloseSession() {
 }
 
 // Connect connects to the given host specified in the configuration
-func (sshConfig *SSHConfig) Connect() error {
-	clientConfig, err := sshConfig.getClientConfig()
-	if err != nil {
+func (sshConfig *SSHConfig) Connect() (err error) {
+	sshConfig.CloseSession()
+
+	if err = sshConfig.connectClient(); err != nil {
 		return err
 	}
 
-	sshConfig.CloseSession()
+	sshConfig.session, err = sshConfig.client.NewSession()
+	return err
+}
 
+// connectClient connects the client to the host, unless it's already connected
+func (sshConfig *SSHConfig) connectClient() (err error) {
 	if sshConfig.client == nil {
-		sshConfig.client, err = ssh.Dial("tcp", sshConfig.HostIPOrAddr+":22", clientConfig)
+		sshConfig.client, err = sshConfig.dial()
 		if err != nil {
 			return err
 		}
@@ -154,9 +193,7 @@ func (sshConfig *SSHConfig) Connect() error {
 			}
 		}(sshConfig.client)
 	}
-
-	sshConfig.session, err = sshConfig.client.NewSession()
-	return err
+	return
 }
 
 // Copy copies the contents of the specified io.Reader to the given remote location.
@@ -227,6 +264,119 @@ func (sshConfig *SSHConfig) CopyFromFile(file os.File, remotePath string, permis
 	return err
 }
 
+// CopyDirectory copies the given local directory, and everything in it, to the remote directory using the recursive scp protocol.
+// The remote directory is created if it doesn't exist, files that already exist in it are overwritten.
+func (sshConfig *SSHConfig) CopyDirectory(localDir, remoteDir string) (err error) {
+	if expandedLocalDir, err := Expand(localDir); err == nil {
+		localDir = expandedLocalDir
+	} else {
+		return err
+	}
+	if stat, err := os.Stat(localDir); err != nil {
+		return err
+	} else if !stat.IsDir() {
+		return fmt.Errorf("%s is not a directory", localDir)
+	}
+	remoteDir = path.Clean(remoteDir)
+	if sshConfig.session == nil {
+		if !sshConfig.autoOpenSession {
+			panic("No SSH session opened.")
+		}
+		err = sshConfig.Connect()
+		if err != nil { // Failure to connect. Could be due to invalid host name, or host that cannot be reached.
+			return err
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		writeErr error
+	)
+	session := sshConfig.session
+	w, err := session.StdinPipe()
+	if err != nil {
+		sshConfig.CloseSession()
+		return err
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		writeErr = writeSCPDirectory(w, localDir, path.Base(remoteDir))
+		w.Close() // scp exits once the input ends
+	}()
+
+	// the remote scp exits with a non-zero status if any file or directory couldn't be written
+	runErr := session.Run("sudo /usr/bin/scp -r -t " + path.Dir(remoteDir))
+	wg.Wait()                // waits for the coroutine to complete
+	sshConfig.CloseSession() // A session only accepts one call to Run/Shell, etc, so close the session
+	if writeErr != nil {
+		return writeErr
+	}
+	if runErr != nil {
+		return fmt.Errorf("unable to copy %s to %s: %v", localDir, remoteDir, runErr)
+	}
+	return nil
+}
+
+// writeSCPDirectory writes the local directory as the named directory in the recursive scp protocol:
+// a D record starts a directory, a C record followed by the contents and a 0 byte sends a file, an E record ends a directory.
+func writeSCPDirectory(w io.Writer, localDir, name string) (err error) {
+	stat, err := os.Stat(localDir)
+	if err != nil {
+		return
+	}
+	if _, err = fmt.Fprintf(w, "D%04o 0 %s\n", stat.Mode().Perm(), name); err != nil {
+		return
+	}
+	entries, err := ioutil.ReadDir(localDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		entryPath := path.Join(localDir, entry.Name())
+		switch {
+		case entry.IsDir():
+			{
+				err = writeSCPDirectory(w, entryPath, entry.Name())
+			}
+		case entry.Mode().IsRegular():
+			{
+				err = writeSCPFile(w, entryPath, entry)
+			}
+		default:
+			{
+				DebugLog.Printf("Skipping %s, only files and directories are copied\n", entryPath)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+	_, err = fmt.Fprint(w, "E\n")
+	return
+}
+
+// writeSCPFile writes the local file in the scp protocol
+func writeSCPFile(w io.Writer, filename string, stat os.FileInfo) (err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if _, err = fmt.Fprintf(w, "C%04o %d %s\n", stat.Mode().Perm(), stat.Size(), stat.Name()); err != nil {
+		return
+	}
+	writtenCount, err := io.Copy(w, file)
+	if err != nil {
+		return
+	}
+	if writtenCount != stat.Size() {
+		return fmt.Errorf("Copied size: %d not equal to file size: %d", writtenCount, stat.Size())
+	}
+	_, err = w.Write([]byte{0}) // Send 0 byte to indicate EOF
+	return
+}
+
 // CopyLocalFileToRemoteFile copies the given local filename to the remote filename with the given permissions
 // localFilename must be the filename of a local file and remoteFilename must be the remote filename, not a directory.
 func (sshConfig *SSHConfig) CopyLocalFileToRemoteFile(localFilename, remoteFilename, permissions string) error {
@@ -251,6 +401,32 @@ func (sshConfig *SSHConfig) CreateDirectory(path string) (err error) {
 	return
 }
 
+// Dial connects to the given address as seen from the host specified in the given SSHConfig,
+// tunneling the connection through the SSH connection to the host.
+// It's safe to be called by multiple nodes at the same time, eg, for a jump host.
+func (sshConfig *SSHConfig) Dial(network, address string) (net.Conn, error) {
+	sshConfig.mutex.Lock()
+	if err := sshConfig.connectClient(); err != nil {
+		sshConfig.mutex.Unlock()
+		return nil, err
+	}
+	client := sshConfig.client
+	sshConfig.mutex.Unlock()
+
+	conn, err := client.Dial(network, address)
+	if err != nil {
+		// reconnect the next time if the connection was lost, the other tunnels through it are lost too
+		if _, _, keepAliveErr := client.SendRequest("keepalive@golang.org", true, nil); keepAliveErr != nil {
+			sshConfig.mutex.Lock()
+			if sshConfig.client == client {
+				sshConfig.CloseClient()
+			}
+			sshConfig.mutex.Unlock()
+		}
+	}
+	return conn, err
+}
+
 // Destroy closes the connection to the client and clears the privatKey, user and host stored in the configuration.
 func (sshConfig *SSHConfig) Destroy() {
 	sshConfig.Close()
@@ -287,30 +463,50 @@ func (sshConfig *SSHConfig) FileExists(file string) (result bool, err error) {
 	return sshConfig.DirectoryExists(file)
 }
 
-func (sshConfig *SSHConfig) getClientConfig() (*ssh.ClientConfig, error) {
-	var key ssh.Signer
-	if sshConfig.parsedKey == nil {
-		var err error
-		key, err = ssh.ParsePrivateKey([]byte(sshConfig.privateKey))
-		if err != nil {
-			return nil, err
+// dial connects to the host, trying each authentication method in turn until the host accepts one of them.
+// Errors that aren't due to authentication are returned without trying the next methods.
+func (sshConfig *SSHConfig) dial() (client *ssh.Client, err error) {
+	var msg string
+	for _, method := range sshConfig.authMethods() {
+		var (
+			clientConfig *ssh.ClientConfig
+			closer       io.Closer
+		)
+		clientConfig, closer, err = sshConfig.getClientConfig(method)
+		if err == nil {
+			client, err = sshConfig.dialHost(clientConfig)
+			if closer != nil {
+				closer.Close()
+			}
+			if err == nil {
+				return
+			}
+			if !isAuthError(err) {
+				return nil, err
+			}
 		}
-		sshConfig.parsedKey = key
-	} else {
-		key = sshConfig.parsedKey
+		msg = fmt.Sprintf("%s\n  %s: %v", msg, method, err)
+	}
+	return nil, fmt.Errorf("unable to authenticate as %s on %s with any authentication method:%s", sshConfig.user, sshConfig.HostIPOrAddr, msg)
+}
+
+// getClientConfig returns the client configuration to authenticate with the given method,
+// and the connection to ssh-agent to close once authenticated, if any.
+func (sshConfig *SSHConfig) getClientConfig(method string) (*ssh.ClientConfig, io.Closer, error) {
+	auth, closer, err := sshConfig.getAuthMethod(method)
+	if err != nil {
+		return nil, nil, err
 	}
 	// Authentication
 	config := &ssh.ClientConfig{
-		User: sshConfig.user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(key),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            sshConfig.user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: sshConfig.hostKeyCallback(),
 	}
 	if sshTimeout != 0 {
 		config.Timeout = sshTimeout
 	}
-	return config, nil
+	return config, closer, nil
 }
 
 // GetOS returns the OS that is running on the host specified in the given SSHConfig
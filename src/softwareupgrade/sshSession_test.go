@@ -1,10 +1,13 @@
 package softwareupgrade
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"testing"
 	"time"
@@ -175,3 +178,60 @@ func TestSSHConfig_GetOS(t *testing.T) {
 	}
 	sshConfig.Run("uname")
 }
+
+func Test_writeSCPDirectory(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	if err = os.Mkdir(filepath.Join(tempDir, "lib"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(tempDir, "geth"), []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(tempDir, "lib", "a.so"), []byte("so"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(tempDir, 0755)
+
+	var b bytes.Buffer
+	if err = writeSCPDirectory(&b, tempDir, "bin"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "D0755 0 bin\nC0755 6 geth\nbinary\x00D0750 0 lib\nC0644 2 a.so\nso\x00E\nE\n"
+	if b.String() != expected {
+		t.Fatalf("Expected %q, but got %q", expected, b.String())
+	}
+}
+
+func TestSSHConfig_CopyDirectory(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	signer, key := newTestKey(t)
+	keyFilename := filepath.Join(tempDir, "id_rsa")
+	writeTestKey(t, keyFilename, key, "")
+	localDir := filepath.Join(tempDir, "bin")
+	if err = os.Mkdir(localDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(localDir, "geth"), []byte("binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	server := startTestSSHServer(t, signer.PublicKey())
+	defer server.close()
+	defer ClearSSHConfigCache()
+
+	sshConfig := NewSSHConfigFromInfo(SSHInfo{SSHCert: keyFilename, SSHUserName: "ubuntu", SSHHostKeyPolicy: CHostKeyPolicyOff}, server.address())
+	if err = sshConfig.CopyDirectory(localDir, "/opt/geth/bin"); err != nil {
+		t.Fatal(err)
+	}
+	server.failing = "scp"
+	if err = sshConfig.CopyDirectory(localDir, "/opt/geth/bin"); err == nil {
+		t.Fatal("A failed scp should fail the copy")
+	}
+}
@@ -0,0 +1,174 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+var (
+	sshKeyCache      map[string]ssh.Signer // the keys parsed, so that the passphrase of each key is only read once
+	sshKeyCacheMutex sync.Mutex
+
+	// PassphrasePrompt asks the operator for the passphrase of the encrypted key in the given file
+	PassphrasePrompt = promptPassphrase
+)
+
+// clearSSHKeyCache forgets the keys parsed, and their passphrases
+func clearSSHKeyCache() {
+	sshKeyCacheMutex.Lock()
+	defer sshKeyCacheMutex.Unlock()
+	sshKeyCache = nil
+}
+
+// authMethods returns the authentication methods to try in order. When none is specified,
+// the key in ssh_cert is used, or ssh-agent if there's no ssh_cert.
+func (sshConfig *SSHConfig) authMethods() []string {
+	if len(sshConfig.auth) > 0 {
+		return sshConfig.auth
+	}
+	if sshConfig.keyFilename != "" {
+		return []string{CSSHAuthKey}
+	}
+	return []string{CSSHAuthAgent}
+}
+
+// getAuthMethod returns the ssh.AuthMethod for the given authentication method,
+// and the connection to ssh-agent to close once authenticated, if any.
+func (sshConfig *SSHConfig) getAuthMethod(method string) (result ssh.AuthMethod, closer io.Closer, err error) {
+	switch {
+	case method == CSSHAuthAgent:
+		{
+			socket := os.Getenv(CSSHAuthSock)
+			if socket == "" {
+				return nil, nil, fmt.Errorf("%s is not set", CSSHAuthSock)
+			}
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				return nil, nil, err
+			}
+			return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), conn, nil
+		}
+	case method == CSSHAuthKey, strings.HasPrefix(method, CSSHAuthKeyPrefix):
+		{
+			filename := sshConfig.keyFilename
+			if method != CSSHAuthKey {
+				filename = method[len(CSSHAuthKeyPrefix):]
+				if expandedFilename, err := Expand(filename); err == nil {
+					filename = expandedFilename
+				}
+			}
+			if filename == "" {
+				return nil, nil, fmt.Errorf("ssh_cert is not specified")
+			}
+			signer, err := loadSSHKey(filename, sshConfig.passphrase)
+			if err != nil {
+				return nil, nil, err
+			}
+			return ssh.PublicKeys(signer), nil, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown authentication method, it must be agent, key or key:FILENAME")
+}
+
+// loadSSHKey parses the private key in the given file, reading its passphrase from passphraseSource if it's encrypted
+func loadSSHKey(filename, passphraseSource string) (signer ssh.Signer, err error) {
+	sshKeyCacheMutex.Lock()
+	defer sshKeyCacheMutex.Unlock()
+	if signer = sshKeyCache[filename]; signer != nil {
+		return
+	}
+
+	data, err := ReadDataFromFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read key %s, error: %v", filename, err)
+	}
+	signer, err = ssh.ParsePrivateKey(data)
+	if _, encrypted := err.(*ssh.PassphraseMissingError); encrypted {
+		var passphrase []byte
+		if passphrase, err = readPassphrase(filename, passphraseSource); err != nil {
+			return
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse key %s, error: %v", filename, err)
+	}
+
+	if sshKeyCache == nil {
+		sshKeyCache = make(map[string]ssh.Signer)
+	}
+	sshKeyCache[filename] = signer
+	return
+}
+
+// readPassphrase reads the passphrase of the key in the given file from the operator (prompt),
+// an environment variable (env:NAME) or a file (file:FILENAME)
+func readPassphrase(filename, source string) (result []byte, err error) {
+	switch {
+	case source == "", source == CSSHPassphrasePrompt:
+		{
+			return PassphrasePrompt(filename)
+		}
+	case strings.HasPrefix(source, CSSHPassphraseEnvPrefix):
+		{
+			name := source[len(CSSHPassphraseEnvPrefix):]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return nil, fmt.Errorf("unable to read the passphrase of %s as the environment variable %s is not set", filename, name)
+			}
+			return []byte(value), nil
+		}
+	case strings.HasPrefix(source, CSSHPassphraseFilePrefix):
+		{
+			passphraseFilename := source[len(CSSHPassphraseFilePrefix):]
+			if expandedFilename, err := Expand(passphraseFilename); err == nil {
+				passphraseFilename = expandedFilename
+			}
+			if result, err = ReadDataFromFile(passphraseFilename); err != nil {
+				return nil, fmt.Errorf("unable to read the passphrase of %s, error: %v", filename, err)
+			}
+			return []byte(strings.TrimRight(string(result), "\r\n")), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown ssh_passphrase %q, it must be prompt, env:NAME or file:FILENAME", source)
+}
+
+// promptPassphrase reads the passphrase from the terminal, without echoing it
+func promptPassphrase(filename string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return nil, fmt.Errorf("unable to prompt for the passphrase of %s as the input isn't a terminal", filename)
+	}
+	fmt.Printf("Enter the passphrase of %s: ", filename)
+	defer fmt.Println()
+	return terminal.ReadPassword(fd)
+}
+
+// isAuthError returns true if the error is due to the server rejecting the authentication
+func isAuthError(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// SSHHost returns the host of a node, without the port if the node specifies one
+func SSHHost(hostIPOrAddr string) string {
+	if host, _, err := net.SplitHostPort(hostIPOrAddr); err == nil {
+		return host
+	}
+	return hostIPOrAddr
+}
+
+// sshAddress returns the address to connect to, port 22 is used if the host doesn't specify a port
+func sshAddress(hostIPOrAddr string) string {
+	if _, _, err := net.SplitHostPort(hostIPOrAddr); err == nil {
+		return hostIPOrAddr
+	}
+	return net.JoinHostPort(hostIPOrAddr, "22")
+}
@@ -0,0 +1,276 @@
+package softwareupgrade
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// testSSHServer is an in-process SSH server that accepts a single key, answers ok to every command
+// and forwards direct-tcpip channels
+type testSSHServer struct {
+	listener    net.Listener
+	config      *ssh.ServerConfig
+	hostKey     ssh.PublicKey
+	connections int32  // the number of authenticated connections
+	failing     string // commands containing it exit with status 1
+	mutex       sync.Mutex
+	commands    []string // the commands run, in order
+}
+
+func startTestSSHServer(t *testing.T, authorized ssh.PublicKey) (server *testSSHServer) {
+	hostKey, _ := newTestKey(t)
+	server = &testSSHServer{hostKey: hostKey.PublicKey(), config: &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}}
+	server.config.AddHostKey(hostKey)
+	var err error
+	if server.listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := server.listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleConn(conn)
+		}
+	}()
+	return
+}
+
+func (server *testSSHServer) address() string {
+	return server.listener.Addr().String()
+}
+
+// ran returns the commands run so far, in order
+func (server *testSSHServer) ran() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string(nil), server.commands...)
+}
+
+func (server *testSSHServer) close() {
+	server.listener.Close()
+}
+
+func (server *testSSHServer) handleConn(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, server.config)
+	if err != nil {
+		return
+	}
+	atomic.AddInt32(&server.connections, 1)
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go server.forward(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for request := range channelRequests {
+				if request.Type != "exec" {
+					request.Reply(false, nil)
+					continue
+				}
+				request.Reply(true, nil)
+				var command struct{ Command string }
+				ssh.Unmarshal(request.Payload, &command)
+				server.mutex.Lock()
+				server.commands = append(server.commands, command.Command)
+				server.mutex.Unlock()
+				var status uint32
+				if server.failing != "" && strings.Contains(command.Command, server.failing) {
+					status = 1
+				}
+				channel.Write([]byte("ok\n"))
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+// forward connects a direct-tcpip channel to its destination
+func (server *testSSHServer) forward(newChannel ssh.NewChannel) {
+	var destination struct {
+		DestAddr string
+		DestPort uint32
+		OrigAddr string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &destination); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(destination.DestAddr, strconv.Itoa(int(destination.DestPort))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	io.Copy(channel, conn)
+	channel.Close()
+}
+
+func newTestKey(t *testing.T) (ssh.Signer, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+// writeTestKey writes the key to a file, encrypted with the passphrase unless it's empty
+func writeTestKey(t *testing.T, filename string, key *rsa.PrivateKey, passphrase string) {
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if passphrase != "" {
+		var err error
+		if block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filename, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSSHConfig_EncryptedKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signer, key := newTestKey(t)
+	keyFilename := filepath.Join(dir, "id_rsa")
+	knownHosts := filepath.Join(dir, "known_hosts")
+	writeTestKey(t, keyFilename, key, "secret")
+	server := startTestSSHServer(t, signer.PublicKey())
+	defer server.close()
+	defer ClearSSHConfigCache()
+
+	os.Setenv("TEST_SSH_PASSPHRASE", "secret")
+	defer os.Unsetenv("TEST_SSH_PASSPHRASE")
+	sshConfig := NewSSHConfigFromInfo(SSHInfo{SSHKnownHosts: knownHosts, SSHHostKeyPolicy: CHostKeyPolicyAcceptNew, SSHCert: keyFilename, SSHUserName: "env", SSHPassphrase: "env:TEST_SSH_PASSPHRASE"}, server.address())
+	if result, err := sshConfig.Run("true"); err != nil || result != "ok\n" {
+		t.Fatalf("The passphrase should be read from the environment, result: %q, error: %v", result, err)
+	}
+
+	ClearSSHConfigCache()
+	var prompts int
+	defer func(prompt func(string) ([]byte, error)) { PassphrasePrompt = prompt }(PassphrasePrompt)
+	PassphrasePrompt = func(filename string) ([]byte, error) {
+		prompts++
+		return []byte("secret"), nil
+	}
+	for _, user := range []string{"ubuntu", "admin"} {
+		sshConfig = NewSSHConfigFromInfo(SSHInfo{SSHKnownHosts: knownHosts, SSHHostKeyPolicy: CHostKeyPolicyAcceptNew, SSHCert: keyFilename, SSHUserName: user, SSHPassphrase: "prompt"}, server.address())
+		if _, err = sshConfig.Run("true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if prompts != 1 {
+		t.Fatalf("The passphrase should be asked for once, but was asked for %d times", prompts)
+	}
+
+	ClearSSHConfigCache()
+	passphraseFilename := filepath.Join(dir, "passphrase")
+	if err = ioutil.WriteFile(passphraseFilename, []byte("wrong\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshConfig = NewSSHConfigFromInfo(SSHInfo{SSHKnownHosts: knownHosts, SSHHostKeyPolicy: CHostKeyPolicyAcceptNew, SSHCert: keyFilename, SSHUserName: "file", SSHPassphrase: "file:" + passphraseFilename}, server.address())
+	if _, err = sshConfig.Run("true"); err == nil || !strings.Contains(err.Error(), "unable to parse key") {
+		t.Fatalf("A wrong passphrase should fail to decrypt the key, but the error is %v", err)
+	}
+}
+
+func TestSSHConfig_AuthFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, rejectedKey := newTestKey(t)
+	rejectedKeyFilename := filepath.Join(dir, "rejected")
+	knownHosts := filepath.Join(dir, "known_hosts")
+	writeTestKey(t, rejectedKeyFilename, rejectedKey, "")
+	agentSigner, agentKey := newTestKey(t)
+	server := startTestSSHServer(t, agentSigner.PublicKey())
+	defer server.close()
+	defer ClearSSHConfigCache()
+
+	keyring := agent.NewKeyring()
+	if err = keyring.Add(agent.AddedKey{PrivateKey: agentKey}); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+	defer os.Setenv(CSSHAuthSock, os.Getenv(CSSHAuthSock))
+	os.Setenv(CSSHAuthSock, socket)
+
+	sshConfig := NewSSHConfigFromInfo(SSHInfo{SSHKnownHosts: knownHosts, SSHHostKeyPolicy: CHostKeyPolicyAcceptNew, SSHCert: rejectedKeyFilename, SSHUserName: "ubuntu", SSHAuth: []string{"key", "agent"}}, server.address())
+	if _, err = sshConfig.Run("true"); err != nil {
+		t.Fatalf("The agent should be used when the key is rejected, but the error is %v", err)
+	}
+
+	missingKeyFilename := filepath.Join(dir, "missing")
+	sshConfig = NewSSHConfigFromInfo(SSHInfo{SSHKnownHosts: knownHosts, SSHHostKeyPolicy: CHostKeyPolicyAcceptNew, SSHCert: rejectedKeyFilename, SSHUserName: "admin", SSHAuth: []string{"key", "key:" + missingKeyFilename}}, server.address())
+	_, err = sshConfig.Run("true")
+	if err == nil || !strings.Contains(err.Error(), "unable to authenticate") || !strings.Contains(err.Error(), missingKeyFilename) {
+		t.Fatalf("Every method should be reported when none is accepted, but the error is %v", err)
+	}
+}
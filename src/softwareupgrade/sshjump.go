@@ -0,0 +1,91 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type (
+	// tHandshake is the result of an SSH handshake
+	tHandshake struct {
+		conn     ssh.Conn
+		channels <-chan ssh.NewChannel
+		requests <-chan *ssh.Request
+		err      error
+	}
+)
+
+// jumpHostInfo returns the SSH settings of the jump host at the given index of the node's jump hosts.
+// The jump host is reached through the jump hosts before it, and uses the node's settings that it doesn't specify.
+func jumpHostInfo(sshInfo SSHInfo, index int) (result SSHInfo) {
+	jumpHost := sshInfo.SSHJumpHosts[index]
+	result = sshInfo
+	result.SSHJumpHosts = sshInfo.SSHJumpHosts[:index]
+	if jumpHost.SSHCert != "" {
+		result.SSHCert = jumpHost.SSHCert
+	}
+	if jumpHost.SSHUserName != "" {
+		result.SSHUserName = jumpHost.SSHUserName
+	}
+	if jumpHost.SSHPassphrase != "" {
+		result.SSHPassphrase = jumpHost.SSHPassphrase
+	}
+	if len(jumpHost.SSHAuth) > 0 {
+		result.SSHAuth = jumpHost.SSHAuth
+	}
+	return
+}
+
+// dialHost connects to the host and performs the SSH handshake. When there are jump hosts,
+// the connection is tunneled through a direct-tcpip channel of the last jump host, and the handshake is limited
+// to the client config's timeout, as the channel has no deadline.
+func (sshConfig *SSHConfig) dialHost(clientConfig *ssh.ClientConfig) (*ssh.Client, error) {
+	address := sshAddress(sshConfig.HostIPOrAddr)
+	if sshConfig.jumpHost == nil {
+		return ssh.Dial("tcp", address, clientConfig)
+	}
+	conn, err := sshConfig.jumpHost.Dial("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach node %s through jump host %s, error: %v", sshConfig.HostIPOrAddr, sshConfig.jumpHost.HostIPOrAddr, err)
+	}
+	result, err := handshake(conn, address, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewClient(result.conn, result.channels, result.requests), nil
+}
+
+// handshake performs the SSH handshake over conn, closing conn if it fails, or if it doesn't complete within the
+// client config's timeout.
+func handshake(conn net.Conn, address string, clientConfig *ssh.ClientConfig) (result tHandshake, err error) {
+	done := make(chan tHandshake, 1)
+	go func() {
+		var result tHandshake
+		result.conn, result.channels, result.requests, result.err = ssh.NewClientConn(conn, address, clientConfig)
+		done <- result
+	}()
+	var timeout <-chan time.Time
+	if clientConfig.Timeout > 0 {
+		timer := time.NewTimer(clientConfig.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case result = <-done:
+		{
+			err = result.err
+		}
+	case <-timeout:
+		{
+			err = fmt.Errorf("the SSH handshake with %s didn't complete within %s", address, clientConfig.Timeout)
+		}
+	}
+	if err != nil {
+		// closing the connection ends the handshake if it's still running
+		conn.Close()
+	}
+	return
+}
@@ -0,0 +1,129 @@
+package softwareupgrade
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSSHConfig_JumpHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bastionSigner, bastionKey := newTestKey(t)
+	bastionKeyFilename := filepath.Join(dir, "bastion")
+	writeTestKey(t, bastionKeyFilename, bastionKey, "")
+	nodeSigner, nodeKey := newTestKey(t)
+	nodeKeyFilename := filepath.Join(dir, "node")
+	writeTestKey(t, nodeKeyFilename, nodeKey, "")
+	knownHosts := filepath.Join(dir, "known_hosts")
+
+	bastion := startTestSSHServer(t, bastionSigner.PublicKey())
+	defer bastion.close()
+	nodes := []*testSSHServer{startTestSSHServer(t, nodeSigner.PublicKey()), startTestSSHServer(t, nodeSigner.PublicKey())}
+	for _, node := range nodes {
+		defer node.close()
+	}
+	defer ClearSSHConfigCache()
+
+	sshInfo := SSHInfo{SSHCert: nodeKeyFilename, SSHUserName: "ubuntu", SSHKnownHosts: knownHosts, SSHHostKeyPolicy: CHostKeyPolicyAcceptNew,
+		SSHJumpHosts: []JumpHost{{Host: bastion.address(), SSHCert: bastionKeyFilename, SSHUserName: "jump"}}}
+	for _, node := range nodes {
+		if result, err := NewSSHConfigFromInfo(sshInfo, node.address()).Run("true"); err != nil || result != "ok\n" {
+			t.Fatalf("The node should be reached through the jump host, result: %q, error: %v", result, err)
+		}
+	}
+	if connections := atomic.LoadInt32(&bastion.connections); connections != 1 {
+		t.Fatalf("The connection to the jump host should be shared, but there were %d connections", connections)
+	}
+
+	ClearSSHConfigCache()
+	sshInfo.SSHJumpHosts[0].SSHCert = nodeKeyFilename
+	if _, err = NewSSHConfigFromInfo(sshInfo, nodes[0].address()).Run("true"); err == nil || !strings.Contains(err.Error(), "through jump host") {
+		t.Fatalf("A jump host that rejects the key should be reported, but the error is %v", err)
+	}
+}
+
+func TestSSHConfig_JumpHostHandshakeTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signer, key := newTestKey(t)
+	keyFilename := filepath.Join(dir, "id_rsa")
+	writeTestKey(t, keyFilename, key, "")
+	bastion := startTestSSHServer(t, signer.PublicKey())
+	defer bastion.close()
+	defer ClearSSHConfigCache()
+	defer SetSSHTimeout(sshTimeout)
+	SetSSHTimeout(500 * time.Millisecond)
+
+	// the node accepts the connection, but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	sshInfo := SSHInfo{SSHCert: keyFilename, SSHUserName: "ubuntu", SSHHostKeyPolicy: CHostKeyPolicyOff,
+		SSHJumpHosts: []JumpHost{{Host: bastion.address()}}}
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewSSHConfigFromInfo(sshInfo, listener.Addr().String()).Run("true")
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err == nil || !strings.Contains(err.Error(), "didn't complete") {
+			t.Fatalf("A node that doesn't answer the handshake should time out, but the error is %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("The handshake through the jump host should time out")
+	}
+}
+
+func TestUpgradeConfig_PinHostKeysThroughJumpHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	signer, key := newTestKey(t)
+	keyFilename := filepath.Join(dir, "id_rsa")
+	writeTestKey(t, keyFilename, key, "")
+	bastion := startTestSSHServer(t, signer.PublicKey())
+	defer bastion.close()
+	node := startTestSSHServer(t, signer.PublicKey())
+	defer node.close()
+	defer ClearSSHConfigCache()
+
+	var config UpgradeConfig
+	config.Common.SSHCert = keyFilename
+	config.Common.SSHHostKeyPolicy = CHostKeyPolicyStrict
+	config.Common.SSHJumpHosts = []JumpHost{{Host: bastion.address()}}
+	config.Common.SoftwareGroup = map[string][]string{"Quorum-Validators": {"quorum"}}
+	config.SoftwareGroupNodes = map[string][]string{"Quorum-Validators": {node.address()}}
+	knownHosts := filepath.Join(dir, "known_hosts")
+
+	pins := config.PinHostKeys(knownHosts)
+	if len(pins) != 2 || pins[0].Node != bastion.address() || pins[0].Status != hostKeyPinned || pins[1].Status != hostKeyPinned || pins[1].Err != nil {
+		t.Fatalf("The jump host should be pinned before the node, but the result is %+v", pins)
+	}
+}
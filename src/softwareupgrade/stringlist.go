@@ -0,0 +1,17 @@
+package softwareupgrade
+
+import "strings"
+
+type (
+	// StringList is a flag that can be specified more than once, each value is appended to the list
+	StringList []string
+)
+
+func (list *StringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *StringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
@@ -17,6 +17,16 @@ func IntToStr(value int) string {
 	return strconv.Itoa(value)
 }
 
+// stringInSlice returns true if the slice contains the given string
+func stringInSlice(value string, slice []string) bool {
+	for i := range slice {
+		if slice[i] == value {
+			return true
+		}
+	}
+	return false
+}
+
 // Expand expands the ~ in the given path to the home directory
 func Expand(path string) (string, error) {
 	if len(path) == 0 || path[0] != '~' {
@@ -0,0 +1,141 @@
+package softwareupgrade
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+type (
+	// TemplateData contains the variables available to the files rendered as templates, and to the commands, for a node
+	TemplateData struct {
+		Node           string            // the hostname of the node
+		Group          string            // the software group of the node
+		Software       string            // the software being upgraded
+		BackupSuffix   string            // the suffix of the backups made in this session
+		RemoteFilename string            // the remote filename of the first file to copy
+		Vars           map[string]string // the custom variables of the node
+	}
+
+	// renderedHasher calculates the hashes of files rendered as templates for a node
+	renderedHasher struct {
+		nodeInfo *NodeInfoContainer
+	}
+)
+
+// RenderTemplate renders the given local file as a text/template with the node's template data.
+// Referring to a variable that doesn't exist is an error.
+func (nodeInfo *NodeInfoContainer) RenderTemplate(filename string) (result []byte, err error) {
+	content, err := ReadDataFromFile(filename)
+	if err != nil {
+		return
+	}
+	tmpl, err := template.New(filename).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return
+	}
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, nodeInfo.TemplateData); err != nil {
+		return
+	}
+	result = b.Bytes()
+	return
+}
+
+// ExpandCommand expands the placeholders, such as {{.Node}}, in the command with the node's template data.
+// Referring to a variable that doesn't exist is an error.
+func (nodeInfo *NodeInfoContainer) ExpandCommand(cmd string) (result string, err error) {
+	if !strings.Contains(cmd, "{{") {
+		return cmd, nil
+	}
+	tmpl, err := template.New("command").Option("missingkey=error").Parse(cmd)
+	if err != nil {
+		return cmd, err
+	}
+	var b bytes.Buffer
+	if err = tmpl.Execute(&b, nodeInfo.TemplateData); err != nil {
+		return cmd, err
+	}
+	return b.String(), nil
+}
+
+// expandCommands expands the placeholders in the start, stop, pre-upgrade, post-upgrade and exec commands.
+// Commands that can't be expanded are left unchanged, and an error for each of them is returned.
+func (nodeInfo *NodeInfoContainer) expandCommands() (err error) {
+	var msg string
+	expand := func(cmd string) string {
+		result, expandErr := nodeInfo.ExpandCommand(cmd)
+		if expandErr != nil {
+			msg = fmt.Sprintf("%s%q: %v\n", msg, cmd, expandErr)
+		}
+		return result
+	}
+	// the command slices are shared with the configuration, so they are replaced rather than updated
+	expandAll := func(cmds []string) (result []string) {
+		for _, cmd := range cmds {
+			result = append(result, expand(cmd))
+		}
+		return
+	}
+	nodeInfo.StartCmd = expand(nodeInfo.StartCmd)
+	nodeInfo.StopCmd = expand(nodeInfo.StopCmd)
+	nodeInfo.PreUpgrade = expandAll(nodeInfo.PreUpgrade)
+	nodeInfo.PostUpgrade = expandAll(nodeInfo.PostUpgrade)
+	nodeInfo.Exec = expandAll(nodeInfo.Exec)
+	if msg != "" {
+		err = errors.New(strings.TrimSpace(msg))
+	}
+	return
+}
+
+// IsTemplate returns true if the local file is rendered as a text/template for each node before it's copied
+func (upgradeStruct UpgradeStruct) IsTemplate() bool {
+	return upgradeStruct.Template != nil && *upgradeStruct.Template
+}
+
+// sourceHasher returns the hasher for the local file of the UpgradeStruct, which hashes the rendered file for templates
+func (nodeInfo *NodeInfoContainer) sourceHasher(upgradeStruct UpgradeStruct, local Hasher) Hasher {
+	if upgradeStruct.IsTemplate() {
+		return &renderedHasher{nodeInfo}
+	}
+	return local
+}
+
+// copyFile copies the local file of the UpgradeStruct to the node, rendering it first if it's a template
+func (nodeInfo *NodeInfoContainer) copyFile(sshConfig *SSHConfig, upgradeStruct UpgradeStruct) (err error) {
+	if !upgradeStruct.IsTemplate() {
+		return sshConfig.CopyLocalFileToRemoteFile(upgradeStruct.SourceFilePath, upgradeStruct.DestFilePath, upgradeStruct.Permissions)
+	}
+	content, err := nodeInfo.RenderTemplate(upgradeStruct.SourceFilePath)
+	if err != nil {
+		return
+	}
+	return sshConfig.Copy(bytes.NewReader(content), upgradeStruct.DestFilePath, upgradeStruct.Permissions, int64(len(content)))
+}
+
+// Md5sum calculates the MD5 hash of the rendered file
+func (hasher *renderedHasher) Md5sum(path string) (result string, err error) {
+	content, err := hasher.nodeInfo.RenderTemplate(path)
+	if err != nil {
+		return
+	}
+	sum := md5.Sum(content)
+	result = hex.EncodeToString(sum[:])
+	return
+}
+
+// Sha256sum calculates the SHA256 hash of the rendered file
+func (hasher *renderedHasher) Sha256sum(path string) (result string, err error) {
+	content, err := hasher.nodeInfo.RenderTemplate(path)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(content)
+	result = hex.EncodeToString(sum[:])
+	return
+}
@@ -0,0 +1,93 @@
+package softwareupgrade
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNodeInfoContainer_RenderTemplate(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	filename := filepath.Join(tempDir, "quorum.conf")
+	content := "[program:{{.Software}}]\nhost={{.Node}} group={{.Group}} backup={{.BackupSuffix}} id={{.Vars.raft_id}}\n"
+	if err = ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	isTemplate := true
+	var config UpgradeConfig
+	config.Common.SoftwareGroup = map[string][]string{"Quorum-Makers": {"quorum"}}
+	config.SoftwareGroupNodes = map[string][]string{"Quorum-Makers": {"node1", "node2"}}
+	config.Software = map[string]UpgradeInfo{
+		"quorum": {Copy: map[string]UpgradeStruct{"1": {SourceFilePath: filename, DestFilePath: "/etc/supervisor/conf.d/quorum.conf", Template: &isTemplate}}},
+	}
+	config.Nodes = map[string]NodeInfoContainer{"node1": {Vars: map[string]string{"raft_id": "1"}}}
+
+	nodeInfo := config.GetNodeUpgradeInfo("node1", "quorum")
+	rendered, err := nodeInfo.RenderTemplate(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[program:quorum]\nhost=node1 group=Quorum-Makers backup=" + GetBackupSuffix() + " id=1\n"
+	if string(rendered) != expected {
+		t.Fatalf("Expected %q, but rendered %q", expected, rendered)
+	}
+
+	// the hashes used to verify the copy are of the rendered file
+	sum := sha256.Sum256(rendered)
+	hash, err := nodeInfo.sourceHasher(nodeInfo.Copy["1"], NewLocalHostHasher()).Sha256sum(filename)
+	if err != nil || hash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("Hash should be of the rendered file, but is %s, error: %v", hash, err)
+	}
+
+	// node2 doesn't define raft_id
+	if _, err = config.GetNodeUpgradeInfo("node2", "quorum").RenderTemplate(filename); err == nil {
+		t.Fatal("Rendering should fail when a variable doesn't exist")
+	}
+}
+
+func TestUpgradeConfig_ValidateCommands(t *testing.T) {
+	var config UpgradeConfig
+	config.Common.SoftwareGroup = map[string][]string{"Quorum-Makers": {"quorum"}}
+	config.SoftwareGroupNodes = map[string][]string{"Quorum-Makers": {"node1", "node2"}}
+	config.Software = map[string]UpgradeInfo{
+		"quorum": {
+			StopCmd:    "sudo supervisorctl stop {{.Software}}",
+			StartCmd:   "sudo supervisorctl start {{.Software}}",
+			PreUpgrade: []string{"sudo cp {{.RemoteFilename}} /tmp/{{.Node}}-{{.Group}}{{.BackupSuffix}}"},
+			Exec:       []string{"echo {{.Vars.raft_id}}"},
+			Copy:       map[string]UpgradeStruct{"1": {SourceFilePath: "geth", DestFilePath: "/usr/local/bin/geth"}},
+		},
+	}
+	config.Nodes = map[string]NodeInfoContainer{
+		"node1": {Vars: map[string]string{"raft_id": "1"}},
+		"node2": {Vars: map[string]string{"raft_id": "2"}},
+	}
+	if err := config.ValidateCommands(); err != nil {
+		t.Fatal(err)
+	}
+
+	nodeInfo := config.GetNodeUpgradeInfo("node2", "quorum")
+	if nodeInfo.StopCmd != "sudo supervisorctl stop quorum" || nodeInfo.Exec[0] != "echo 2" ||
+		nodeInfo.PreUpgrade[0] != "sudo cp /usr/local/bin/geth /tmp/node2-Quorum-Makers"+GetBackupSuffix() {
+		t.Fatalf("Commands not expanded: %+v", nodeInfo.UpgradeInfo)
+	}
+	if config.Software["quorum"].Exec[0] != "echo {{.Vars.raft_id}}" {
+		t.Fatal("Expanding the commands of a node shouldn't change the configuration")
+	}
+
+	delete(config.Nodes, "node2")
+	config.Software["quorum"] = UpgradeInfo{StopCmd: "sudo supervisorctl stop {{.Service}}", Exec: []string{"echo {{.Vars.raft_id}}"}}
+	err := config.ValidateCommands()
+	if err == nil || !strings.Contains(err.Error(), "{{.Service}}") || !strings.Contains(err.Error(), "node2") {
+		t.Fatalf("Unknown placeholders should fail validation, error: %v", err)
+	}
+}
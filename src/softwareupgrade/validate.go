@@ -0,0 +1,588 @@
+package softwareupgrade
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// ConfigProblem is a problem found in the configuration, Path is the JSON path of the value with the problem
+	ConfigProblem struct {
+		Path    string
+		Message string
+	}
+
+	// configValidator accumulates the problems found in a configuration
+	configValidator struct {
+		config       *UpgradeConfig
+		problems     []ConfigProblem
+		invalidPaths map[string]bool // the paths of the values that couldn't be decoded, so they aren't checked again
+	}
+)
+
+var (
+	durationType     = reflect.TypeOf(Duration{})
+	permissionsRegex = regexp.MustCompile(`^[0-7]{4}$`)
+)
+
+func (problem ConfigProblem) String() string {
+	return fmt.Sprintf("%s: %s", problem.Path, problem.Message)
+}
+
+// ValidateConfig checks the JSON configuration, and returns every problem found, in the order of their JSON paths.
+// It checks the JSON syntax, unknown keys, value types, durations, references between software, groups and nodes,
+// enumerated values, permission formats, regular expressions and command placeholders.
+func ValidateConfig(data []byte) []ConfigProblem {
+	validator := &configValidator{config: &UpgradeConfig{}, invalidPaths: make(map[string]bool)}
+
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		validator.add("$", "invalid JSON: %s", describeJSONError(data, err))
+		return validator.problems
+	}
+	validator.checkKeys("$", document, reflect.TypeOf(UpgradeConfig{}))
+	if err := json.Unmarshal(data, validator.config); err != nil && len(validator.problems) == 0 {
+		// type errors and invalid durations are already reported with their path by checkKeys
+		validator.add("$", "%v", err)
+	}
+	validator.checkConfig()
+
+	sort.SliceStable(validator.problems, func(i, j int) bool {
+		return validator.problems[i].Path < validator.problems[j].Path
+	})
+	return validator.problems
+}
+
+// describeJSONError adds the line and column of a JSON syntax error to its message
+func describeJSONError(data []byte, err error) string {
+	syntaxErr, ok := err.(*json.SyntaxError)
+	if !ok {
+		return err.Error()
+	}
+	// the offset is just after the invalid character
+	line, column := 1, 1
+	for _, b := range data[:syntaxErr.Offset-1] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Sprintf("%v at line %d, column %d", err, line, column)
+}
+
+func (validator *configValidator) add(path, format string, args ...interface{}) {
+	if validator.invalidPaths[path] {
+		return
+	}
+	validator.problems = append(validator.problems, ConfigProblem{path, fmt.Sprintf(format, args...)})
+}
+
+// checkKeys reports the keys in the JSON value that don't correspond to a field of the Go type,
+// the values that don't match the type of their field, and invalid durations. JSON keys are matched to fields case-insensitively, like encoding/json does.
+func (validator *configValidator) checkKeys(path string, value interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		if text, ok := value.(string); ok {
+			if _, err := time.ParseDuration(text); err != nil {
+				validator.add(path, "invalid duration %q, eg, 30s, 5m", text)
+				validator.invalidPaths[path] = true
+			}
+		} else if _, ok := value.(float64); !ok {
+			validator.addTypeProblem(path, "a duration, eg, 30s, 5m", value)
+		}
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		{
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				validator.addTypeProblem(path, "an object", value)
+				return
+			}
+			fields := jsonFields(t)
+			for _, key := range sortedKeys(object) {
+				fieldType, ok := fields[strings.ToLower(key)]
+				if !ok {
+					validator.add(path+"."+key, "unknown key")
+					continue
+				}
+				validator.checkKeys(path+"."+key, object[key], fieldType)
+			}
+		}
+	case reflect.Map:
+		{
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				validator.addTypeProblem(path, "an object", value)
+				return
+			}
+			for _, key := range sortedKeys(object) {
+				validator.checkKeys(path+"."+key, object[key], t.Elem())
+			}
+		}
+	case reflect.Slice:
+		{
+			array, ok := value.([]interface{})
+			if !ok {
+				validator.addTypeProblem(path, "an array", value)
+				return
+			}
+			for i := range array {
+				validator.checkKeys(fmt.Sprintf("%s[%d]", path, i), array[i], t.Elem())
+			}
+		}
+	case reflect.String:
+		{
+			if _, ok := value.(string); !ok {
+				validator.addTypeProblem(path, "a string", value)
+			}
+		}
+	case reflect.Bool:
+		{
+			if _, ok := value.(bool); !ok {
+				validator.addTypeProblem(path, "true or false", value)
+			}
+		}
+	case reflect.Int:
+		{
+			if number, ok := value.(float64); !ok || number != float64(int(number)) {
+				validator.addTypeProblem(path, "an integer", value)
+			}
+		}
+	}
+}
+
+// addTypeProblem reports a value that doesn't have the expected type, null is accepted for every type
+func (validator *configValidator) addTypeProblem(path, expected string, value interface{}) {
+	if value == nil {
+		return
+	}
+	found, _ := json.Marshal(value)
+	validator.add(path, "expected %s, but found %s", expected, found)
+	validator.invalidPaths[path] = true
+}
+
+// jsonFields returns the types of the fields of the struct type by their lower case JSON names,
+// including the fields of embedded structs.
+func jsonFields(t reflect.Type) (result map[string]reflect.Type) {
+	result = make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			for name, fieldType := range jsonFields(field.Type) {
+				result[name] = fieldType
+			}
+			continue
+		}
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		result[strings.ToLower(tag)] = field.Type
+	}
+	return
+}
+
+func sortedKeys(object map[string]interface{}) (result []string) {
+	for key := range object {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return
+}
+
+func sortedStrings(values map[string]bool) (result []string) {
+	for value := range values {
+		result = append(result, value)
+	}
+	sort.Strings(result)
+	return
+}
+
+// checkConfig checks the references and values of the parsed configuration
+func (validator *configValidator) checkConfig() {
+	config := validator.config
+	common := &config.Common
+
+	if common.SSHTimeout != "" {
+		if _, err := time.ParseDuration(common.SSHTimeout); err != nil {
+			validator.add("$.common.ssh_timeout", "invalid duration %q, eg, 30s, 5m", common.SSHTimeout)
+		}
+	}
+	validator.checkSSHInfo("$.common", common.SSHInfo)
+
+	knownGroups := make(map[string]bool)
+	for groupName := range common.SoftwareGroup {
+		knownGroups[groupName] = true
+	}
+	for _, groupName := range sortedStrings(knownGroups) {
+		path := "$.common.software_group." + groupName
+		listed := make(map[string]bool)
+		for i, software := range common.SoftwareGroup[groupName] {
+			if _, ok := config.Software[software]; !ok {
+				validator.add(fmt.Sprintf("%s[%d]", path, i), "software %q is not defined in software", software)
+			}
+			if listed[software] {
+				validator.add(fmt.Sprintf("%s[%d]", path, i), "software %q is listed more than once", software)
+			}
+			listed[software] = true
+		}
+		if _, ok := config.SoftwareGroupNodes[groupName]; !ok {
+			validator.add(path, "software group has no nodes in groupnodes")
+		}
+	}
+
+	// a node must belong to a single group, and be listed once
+	nodeGroups := make(map[string]string)
+	groupNodeNames := make(map[string]bool)
+	for groupName := range config.SoftwareGroupNodes {
+		groupNodeNames[groupName] = true
+	}
+	for _, groupName := range sortedStrings(groupNodeNames) {
+		path := "$.groupnodes." + groupName
+		if !knownGroups[groupName] {
+			validator.add(path, "software group is not defined in common.software_group")
+		}
+		for i, node := range config.SoftwareGroupNodes[groupName] {
+			nodePath := fmt.Sprintf("%s[%d]", path, i)
+			if node == "" {
+				validator.add(nodePath, "node name is empty")
+				continue
+			}
+			if previousGroup, ok := nodeGroups[node]; ok {
+				if previousGroup == groupName {
+					validator.add(nodePath, "node %q is listed more than once", node)
+				} else {
+					validator.add(nodePath, "node %q is also listed in software group %s", node, previousGroup)
+				}
+				continue
+			}
+			nodeGroups[node] = groupName
+		}
+	}
+
+	softwareNames := make(map[string]bool)
+	for software := range config.Software {
+		softwareNames[software] = true
+	}
+	for _, software := range sortedStrings(softwareNames) {
+		path := "$.software." + software
+		upgradeInfo := config.Software[software]
+		if len(upgradeInfo.Copy) == 0 {
+			validator.add(path, "software has no Copy, so there's nothing to add or upgrade")
+		}
+		validator.checkUpgradeInfo(path, upgradeInfo, true)
+	}
+
+	for _, groupName := range sortedStringKeys(config.GroupOverrides) {
+		path := "$.group_overrides." + groupName
+		if !knownGroups[groupName] {
+			validator.add(path, "software group is not defined in common.software_group")
+		}
+		for software, upgradeInfo := range config.GroupOverrides[groupName] {
+			if !stringInSlice(software, common.SoftwareGroup[groupName]) {
+				validator.add(path+"."+software, "software %q is not in software group %s", software, groupName)
+			}
+			validator.checkUpgradeInfo(path+"."+software, upgradeInfo, false)
+		}
+	}
+
+	nodeNames := make(map[string]bool)
+	for node := range config.Nodes {
+		nodeNames[node] = true
+	}
+	for _, node := range sortedStrings(nodeNames) {
+		path := "$.nodes." + node
+		if _, ok := nodeGroups[node]; !ok {
+			validator.add(path, "node is not listed in groupnodes")
+		}
+		validator.checkUpgradeInfo(path, config.Nodes[node].UpgradeInfo, false)
+		validator.checkSSHInfo(path, config.Nodes[node].SSHInfo)
+	}
+
+	validator.checkGroupSettings(knownGroups)
+
+	// the merged Copy and the placeholders are only checked when the references are valid, as they are merged for each node
+	if len(validator.problems) == 0 {
+		for _, groupName := range config.GetGroupNames() {
+			for _, node := range config.GetGroupNodes(groupName) {
+				for _, software := range config.GetGroupSoftware(groupName) {
+					validator.checkNodeSoftware(config, groupName, node, software)
+				}
+			}
+		}
+	}
+}
+
+// checkNodeSoftware checks the Copy entries of the software merged for the node, and the placeholders in the commands
+// of each layer, reporting the problems at the path of the layer that specifies them.
+func (validator *configValidator) checkNodeSoftware(config *UpgradeConfig, groupName, node, software string) {
+	layers := []struct {
+		path        string
+		upgradeInfo UpgradeInfo
+	}{
+		{"$.software." + software, config.Software[software]},
+		{fmt.Sprintf("$.group_overrides.%s.%s", groupName, software), config.GroupOverrides[groupName][software]},
+		{"$.nodes." + node, config.Nodes[node].UpgradeInfo},
+	}
+	nodeInfo, _ := config.getNodeUpgradeInfo(node, software)
+	for key, upgradeStruct := range nodeInfo.Copy {
+		if !copyIndexInRange(key, len(nodeInfo.Copy)) {
+			// the last layer that adds the entry leaves the gap
+			for i := len(layers) - 1; i >= 0; i-- {
+				if _, ok := layers[i].upgradeInfo.Copy[key]; ok {
+					validator.add(fmt.Sprintf("%s.Copy.%s", layers[i].path, key),
+						"node %s: must be numbered from 0 or 1 without gaps, otherwise it's not copied", node)
+					break
+				}
+			}
+		}
+		if IsURL(upgradeStruct.SourceFilePath) && upgradeStruct.Sha256 == "" {
+			// the last layer that specifies the URL needs the sha256
+			for i := len(layers) - 1; i >= 0; i-- {
+				if layers[i].upgradeInfo.Copy[key].SourceFilePath != "" {
+					validator.add(fmt.Sprintf("%s.Copy.%s.Sha256", layers[i].path, key),
+						"node %s: is required when Local_Filename is a URL", node)
+					break
+				}
+			}
+		}
+	}
+	for _, layer := range layers {
+		layerInfo := &NodeInfoContainer{UpgradeInfo: layer.upgradeInfo, TemplateData: nodeInfo.TemplateData}
+		if err := layerInfo.expandCommands(); err != nil {
+			validator.add(layer.path, "node %s: %v", node, err)
+		}
+	}
+}
+
+// copyIndexInRange returns true if the Copy key is a number that's copied when there are count Copy entries
+func copyIndexInRange(key string, count int) bool {
+	index, err := strconv.Atoi(key)
+	return err == nil && index >= 0 && index <= count
+}
+
+func sortedStringKeys(object map[string]map[string]UpgradeInfo) (result []string) {
+	for key := range object {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return
+}
+
+// checkSSHInfo checks the authentication methods, the host key policy, the passphrase source and the jump hosts
+func (validator *configValidator) checkSSHInfo(path string, sshInfo SSHInfo) {
+	for i, method := range sshInfo.SSHAuth {
+		valid := method == CSSHAuthAgent || method == CSSHAuthKey ||
+			(strings.HasPrefix(method, CSSHAuthKeyPrefix) && len(method) > len(CSSHAuthKeyPrefix))
+		if !valid {
+			validator.add(fmt.Sprintf("%s.ssh_auth[%d]", path, i), "unknown authentication method %q, it must be agent, key or key:FILENAME", method)
+		}
+		if method == CSSHAuthKey && sshInfo.SSHCert == "" && validator.config.Common.SSHCert == "" {
+			validator.add(fmt.Sprintf("%s.ssh_auth[%d]", path, i), "ssh_cert must be specified to authenticate with key")
+		}
+	}
+	switch sshInfo.SSHHostKeyPolicy {
+	case "", CHostKeyPolicyStrict, CHostKeyPolicyAcceptNew, CHostKeyPolicyOff:
+	default:
+		{
+			validator.add(path+".ssh_host_key_policy", "unknown host key policy %q, it must be strict, accept-new or off", sshInfo.SSHHostKeyPolicy)
+		}
+	}
+	if passphrase := sshInfo.SSHPassphrase; passphrase != "" && passphrase != CSSHPassphrasePrompt {
+		var name string
+		if strings.HasPrefix(passphrase, CSSHPassphraseEnvPrefix) {
+			name = passphrase[len(CSSHPassphraseEnvPrefix):]
+		} else if strings.HasPrefix(passphrase, CSSHPassphraseFilePrefix) {
+			name = passphrase[len(CSSHPassphraseFilePrefix):]
+		}
+		if name == "" {
+			validator.add(path+".ssh_passphrase", "invalid passphrase source %q, it must be prompt, env:NAME or file:FILENAME", passphrase)
+		}
+	}
+	for i, jumpHost := range sshInfo.SSHJumpHosts {
+		jumpPath := fmt.Sprintf("%s.ssh_jump_hosts[%d]", path, i)
+		if jumpHost.Host == "" {
+			validator.add(jumpPath, "the host of the jump host is not specified")
+		}
+		jumpInfo := SSHInfo{SSHCert: jumpHost.SSHCert, SSHPassphrase: jumpHost.SSHPassphrase, SSHAuth: jumpHost.SSHAuth}
+		if jumpInfo.SSHCert == "" {
+			jumpInfo.SSHCert = sshInfo.SSHCert
+		}
+		validator.checkSSHInfo(jumpPath, jumpInfo)
+	}
+}
+
+// checkGroupSettings checks the settings in common that refer to software groups
+func (validator *configValidator) checkGroupSettings(knownGroups map[string]bool) {
+	config := validator.config
+	common := &config.Common
+	checkGroup := func(path, groupName string) bool {
+		if !knownGroups[groupName] {
+			validator.add(path, "software group %q is not defined in common.software_group", groupName)
+			return false
+		}
+		return true
+	}
+
+	for groupName, maxParallel := range common.MaxParallel {
+		path := "$.common.max_parallel." + groupName
+		if checkGroup(path, groupName) && maxParallel < 1 {
+			validator.add(path, "must be at least 1")
+		}
+	}
+	for groupName, canary := range common.Canary {
+		path := "$.common.canary." + groupName
+		if !checkGroup(path, groupName) {
+			continue
+		}
+		for i, node := range canary.Nodes {
+			if !stringInSlice(node, config.GetGroupNodes(groupName)) {
+				validator.add(fmt.Sprintf("%s.nodes[%d]", path, i), "node %q is not in software group %s", node, groupName)
+			}
+		}
+		if canary.Count < 0 {
+			validator.add(path+".count", "must not be negative")
+		}
+	}
+	for i, groupName := range common.GroupOrder {
+		checkGroup(fmt.Sprintf("$.common.group_order[%d]", i), groupName)
+	}
+	dependenciesValid := true
+	for groupName, dependencies := range common.DependsOn {
+		path := "$.common.depends_on." + groupName
+		dependenciesValid = checkGroup(path, groupName) && dependenciesValid
+		for i, dependency := range dependencies {
+			dependenciesValid = checkGroup(fmt.Sprintf("%s[%d]", path, i), dependency) && dependenciesValid
+		}
+	}
+	if dependenciesValid {
+		if _, err := config.GetGroupExecutionOrder(); err != nil {
+			validator.add("$.common.depends_on", "%s", strings.TrimSpace(err.Error()))
+		}
+	}
+	for groupName, consensus := range common.Consensus {
+		path := "$.common.consensus." + groupName
+		if !checkGroup(path, groupName) {
+			continue
+		}
+		if _, _, err := config.GetGroupFaultTolerance(groupName); err != nil {
+			validator.add(path, "%v", err)
+		}
+		if consensus.FaultTolerance < 0 {
+			validator.add(path+".fault_tolerance", "must not be negative")
+		}
+	}
+}
+
+// checkUpgradeInfo checks the values of a software definition, or of an override when isSoftware is false
+func (validator *configValidator) checkUpgradeInfo(path string, upgradeInfo UpgradeInfo, isSoftware bool) {
+	switch upgradeInfo.OnFailure {
+	case "", COnFailureRollback:
+	default:
+		{
+			validator.add(path+".on_failure", "must be empty or %q, but is %q", COnFailureRollback, upgradeInfo.OnFailure)
+		}
+	}
+	validator.checkRegex(path+".version_regex", upgradeInfo.VersionRegex)
+	if upgradeInfo.TargetVersion != "" && upgradeInfo.VersionCmd == "" && isSoftware {
+		validator.add(path+".target_version", "requires version_cmd")
+	}
+	for i, check := range upgradeInfo.HealthCheck {
+		checkPath := fmt.Sprintf("%s.health_check[%d]", path, i)
+		switch strings.ToLower(check.Type) {
+		case "":
+			{
+				if check.Cmd == "" {
+					validator.add(checkPath+".cmd", "is required")
+				}
+			}
+		case CHealthCheckGeth:
+		default:
+			{
+				validator.add(checkPath+".type", "must be empty or %q, but is %q", CHealthCheckGeth, check.Type)
+			}
+		}
+		validator.checkRegex(checkPath+".output_regex", check.OutputRegex)
+	}
+
+	for key, upgradeStruct := range upgradeInfo.Copy {
+		copyPath := fmt.Sprintf("%s.Copy.%s", path, key)
+		// an override can specify only some of the entries, they are checked once merged for each node
+		if index, err := strconv.Atoi(key); err != nil || index < 0 {
+			validator.add(copyPath, "must be a number, otherwise it's not copied")
+		} else if isSoftware && !copyIndexInRange(key, len(upgradeInfo.Copy)) {
+			validator.add(copyPath, "must be numbered from 0 or 1 without gaps, otherwise it's not copied")
+		}
+		if isSoftware {
+			if upgradeStruct.SourceFilePath == "" {
+				validator.add(copyPath+".Local_Filename", "is required")
+			}
+			if upgradeStruct.DestFilePath == "" {
+				validator.add(copyPath+".Remote_Filename", "is required")
+			}
+		}
+		if upgradeStruct.DestFilePath != "" && !strings.HasPrefix(upgradeStruct.DestFilePath, "/") {
+			validator.add(copyPath+".Remote_Filename", "must be an absolute path")
+		}
+		if upgradeStruct.Permissions != "" && !permissionsRegex.MatchString(upgradeStruct.Permissions) {
+			validator.add(copyPath+".Permissions", "must be 4 octal digits, eg, 0755, but is %q", upgradeStruct.Permissions)
+		}
+		switch upgradeStruct.VerifyCopy {
+		case "", "md5", "sha256":
+		default:
+			{
+				validator.add(copyPath+".VerifyCopy", "must be empty, \"md5\" or \"sha256\", but is %q", upgradeStruct.VerifyCopy)
+			}
+		}
+		switch upgradeStruct.BackupStrategy {
+		case "", "copy", "move":
+		default:
+			{
+				validator.add(copyPath+".BackupStrategy", "must be empty, \"copy\" or \"move\", but is %q", upgradeStruct.BackupStrategy)
+			}
+		}
+		switch upgradeStruct.Type {
+		case "", CCopyTypeArchive, CCopyTypeDirectory:
+		default:
+			{
+				validator.add(copyPath+".Type", "must be empty, %q or %q, but is %q", CCopyTypeArchive, CCopyTypeDirectory, upgradeStruct.Type)
+			}
+		}
+		if upgradeStruct.DeletesExtraneous() && upgradeStruct.Type != CCopyTypeDirectory && isSoftware {
+			validator.add(copyPath+".Delete", "only applies to the %q type", CCopyTypeDirectory)
+		}
+		if upgradeStruct.Sha256 != "" {
+			if decoded, err := hex.DecodeString(upgradeStruct.Sha256); err != nil || len(decoded) != 32 {
+				validator.add(copyPath+".Sha256", "must be 64 hexadecimal digits")
+			}
+		}
+	}
+}
+
+func (validator *configValidator) checkRegex(path, regex string) {
+	if regex == "" {
+		return
+	}
+	if _, err := regexp.Compile(regex); err != nil {
+		validator.add(path, "invalid regular expression: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package softwareupgrade
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func problemPaths(problems []ConfigProblem) (result []string) {
+	for _, problem := range problems {
+		result = append(result, problem.Path)
+	}
+	return
+}
+
+func TestValidateConfig_Sample(t *testing.T) {
+	data, err := ioutil.ReadFile("../../LaunchUpgrade.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"$.software.crashquorum"}
+	if paths := problemPaths(ValidateConfig(data)); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected problems at %v, but found %v", expected, ValidateConfig(data))
+	}
+}
+
+func TestValidateConfig_Problems(t *testing.T) {
+	data := []byte(`{
+		"common": {
+			"ssh_timeout": "soon",
+			"ssh_passphrase": "stdin",
+			"group_pause_after_upgrade": "1x",
+			"software_group": {"Validators": ["quorum", "vault"]},
+			"max_parallel": {"Makers": 2}
+		},
+		"software": {
+			"quorum": {
+				"stop": "sudo supervisorctl stop quorum",
+				"on_failure": "retry",
+				"Copy": {
+					"1": {"Local_Filename": "/tmp/geth", "Remote_Filename": "/usr/local/bin/geth", "Permissions": "755", "VerifyCopy": "md5sum"},
+					"2": {"Local_Filename": "/tmp/quorum.conf", "Remote_Filename": "/etc/quorum.conf", "BackupStrategy": "rename", "Verbose": true}
+				}
+			}
+		},
+		"groupnodes": {"Validators": ["node1", "node2", "node1"], "Makers": ["node2"]},
+		"nodes": {"node9": {"ssh_username": "ubuntu", "ssh_auth": ["agent", "password"], "ssh_host_key_policy": "ask", "ssh_jump_hosts": [{"ssh_username": "bastion"}]}}
+	}`)
+	expected := []string{
+		"$.common.group_pause_after_upgrade",
+		"$.common.max_parallel.Makers",
+		"$.common.software_group.Validators[1]",
+		"$.common.ssh_passphrase",
+		"$.common.ssh_timeout",
+		"$.groupnodes.Makers",
+		"$.groupnodes.Validators[1]",
+		"$.groupnodes.Validators[2]",
+		"$.nodes.node9",
+		"$.nodes.node9.ssh_auth[1]",
+		"$.nodes.node9.ssh_host_key_policy",
+		"$.nodes.node9.ssh_jump_hosts[0]",
+		"$.software.quorum.Copy.1.Permissions",
+		"$.software.quorum.Copy.1.VerifyCopy",
+		"$.software.quorum.Copy.2.BackupStrategy",
+		"$.software.quorum.Copy.2.Verbose",
+		"$.software.quorum.on_failure",
+	}
+	problems := ValidateConfig(data)
+	if paths := problemPaths(problems); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected problems at %v, but found %v", expected, problems)
+	}
+}
+
+func TestValidateConfig_Types(t *testing.T) {
+	problems := ValidateConfig([]byte(`{"common": {"software_group": {"Validators": []}, "max_parallel": {"Validators": "2"}}, "groupnodes": {"Validators": "node1"}}`))
+	expected := []string{"$.common.max_parallel.Validators", "$.groupnodes.Validators"}
+	if paths := problemPaths(problems); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected problems at %v, but found %v", expected, problems)
+	}
+}
+
+func TestValidateConfig_Syntax(t *testing.T) {
+	problems := ValidateConfig([]byte("{\n  \"common\": {,\n}"))
+	if len(problems) != 1 || problems[0].Path != "$" || !strings.Contains(problems[0].Message, "line 2, column 14") {
+		t.Fatalf("Expected a syntax error at line 2, column 14, but found %v", problems)
+	}
+}
+
+func TestValidateConfig_Overrides(t *testing.T) {
+	config := `{
+		"common": {"software_group": {"Validators": ["quorum"]}},
+		"software": {
+			"quorum": {
+				"stop": "sudo supervisorctl stop quorum",
+				"Copy": {
+					"1": {"Local_Filename": "/tmp/geth", "Remote_Filename": "/usr/local/bin/geth"},
+					"2": {"Local_Filename": "/tmp/quorum.conf", "Remote_Filename": "/etc/quorum.conf"}
+				}
+			}
+		},
+		"group_overrides": {"Validators": {"quorum": {"start": "sudo supervisorctl start {{.Software}}"}}},
+		"groupnodes": {"Validators": ["node1", "node2"]},
+		"nodes": {"node1": {"Copy": {"2": {"Permissions": "0600"}}}}
+	}`
+	if problems := ValidateConfig([]byte(config)); len(problems) != 0 {
+		t.Fatalf("A partial override of a Copy entry should be valid, but found %v", problems)
+	}
+
+	config = strings.Replace(config, `{{.Software}}`, `{{.Vars.name}}`, 1)
+	config = strings.Replace(config, `"Copy": {"2": {"Permissions": "0600"}}`, `"Copy": {"4": {"Local_Filename": "/tmp/genesis.json", "Remote_Filename": "/etc/genesis.json"}}`, 1)
+	expected := []string{"$.group_overrides.Validators.quorum", "$.group_overrides.Validators.quorum", "$.nodes.node1.Copy.4"}
+	problems := ValidateConfig([]byte(config))
+	if paths := problemPaths(problems); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected problems at %v, but found %v", expected, problems)
+	}
+}
+
+func TestValidateConfig_URLOverrides(t *testing.T) {
+	gethSha256 := strings.Repeat("ab", 32)
+	config := `{
+		"common": {"software_group": {"Validators": ["quorum"]}},
+		"software": {"quorum": {"Copy": {"1": {"Local_Filename": "/tmp/geth", "Remote_Filename": "/usr/local/bin/geth", "Sha256": "` + gethSha256 + `"}}}},
+		"group_overrides": {"Validators": {"quorum": {"Copy": {"1": {"Local_Filename": "https://example.com/geth"}}}}},
+		"groupnodes": {"Validators": ["node1", "node2"]},
+		"nodes": {"node1": {"Copy": {"1": {"Sha256": "` + strings.Repeat("cd", 32) + `"}}}}
+	}`
+	if problems := ValidateConfig([]byte(config)); len(problems) != 0 {
+		t.Fatalf("A URL overridden without its sha256 should use the sha256 of the software, but found %v", problems)
+	}
+
+	config = strings.Replace(config, `, "Sha256": "`+gethSha256+`"`, "", 1)
+	expected := []string{"$.group_overrides.Validators.quorum.Copy.1.Sha256"}
+	problems := ValidateConfig([]byte(config))
+	if paths := problemPaths(problems); !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected problems at %v, but found %v", expected, problems)
+	}
+}
@@ -0,0 +1,108 @@
+package softwareupgrade
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type (
+	// SoftwareVersion records the version of a software on a node before and after the upgrade
+	SoftwareVersion struct {
+		Before string `json:"Before"`
+		After  string `json:"After"`
+	}
+
+	// VersionInfo records the versions of each node's software. It is safe to be updated by multiple goroutines.
+	VersionInfo struct {
+		NodeSoftware map[string]map[string]SoftwareVersion `json:"NodeSoftware"` // node -> software -> versions
+		mutex        sync.Mutex
+	}
+)
+
+// NewVersionInfo creates a structure necessary to contain the versions of each node's software
+func NewVersionInfo() *VersionInfo {
+	return &VersionInfo{NodeSoftware: make(map[string]map[string]SoftwareVersion)}
+}
+
+// GetVersion runs the version command on the node, and returns the version extracted by the version regex.
+// If the regex has a capture group, the first group is the version, otherwise the whole match is the version.
+// Without a regex, the trimmed output of the command is the version.
+func (nodeInfo *NodeInfoContainer) GetVersion(runner CommandRunner) (version string, err error) {
+	if nodeInfo.VersionCmd == "" {
+		return
+	}
+	output, err := runner.Run(nodeInfo.VersionCmd)
+	if err != nil {
+		return
+	}
+	if nodeInfo.VersionRegex == "" {
+		version = strings.TrimSpace(output)
+		return
+	}
+	re, err := regexp.Compile(nodeInfo.VersionRegex)
+	if err != nil {
+		return
+	}
+	match := re.FindStringSubmatch(output)
+	switch {
+	case match == nil:
+		{
+			err = fmt.Errorf("version regex %s doesn't match the output of %s", nodeInfo.VersionRegex, nodeInfo.VersionCmd)
+		}
+	case len(match) > 1:
+		{
+			version = match[1]
+		}
+	default:
+		{
+			version = match[0]
+		}
+	}
+	return
+}
+
+// Empty returns true if no version has been recorded
+func (versionInfo *VersionInfo) Empty() bool {
+	versionInfo.mutex.Lock()
+	defer versionInfo.mutex.Unlock()
+	return len(versionInfo.NodeSoftware) == 0
+}
+
+// Get returns the versions recorded for the node's software
+func (versionInfo *VersionInfo) Get(node, software string) SoftwareVersion {
+	versionInfo.mutex.Lock()
+	defer versionInfo.mutex.Unlock()
+	return versionInfo.NodeSoftware[node][software]
+}
+
+// SetBefore records the version of the node's software before the upgrade
+func (versionInfo *VersionInfo) SetBefore(node, software, version string) {
+	versionInfo.update(node, software, func(softwareVersion *SoftwareVersion) {
+		softwareVersion.Before = version
+	})
+}
+
+// SetAfter records the version of the node's software after the upgrade
+func (versionInfo *VersionInfo) SetAfter(node, software, version string) {
+	versionInfo.update(node, software, func(softwareVersion *SoftwareVersion) {
+		softwareVersion.After = version
+	})
+}
+
+func (versionInfo *VersionInfo) update(node, software string, updateFunc func(softwareVersion *SoftwareVersion)) {
+	versionInfo.mutex.Lock()
+	defer versionInfo.mutex.Unlock()
+	if versionInfo.NodeSoftware == nil {
+		versionInfo.NodeSoftware = make(map[string]map[string]SoftwareVersion)
+	}
+	nodeVersions := versionInfo.NodeSoftware[node]
+	if nodeVersions == nil {
+		nodeVersions = make(map[string]SoftwareVersion)
+		versionInfo.NodeSoftware[node] = nodeVersions
+	}
+	softwareVersion := nodeVersions[software]
+	updateFunc(&softwareVersion)
+	nodeVersions[software] = softwareVersion
+}
@@ -0,0 +1,58 @@
+package softwareupgrade
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestNodeInfoContainer_GetVersion(t *testing.T) {
+	output := "Geth\nVersion: 1.8.18-stable\nGit Commit: 1ff152f3\nQuorum Version: 2.2.1\n"
+	tests := []struct {
+		regex   string
+		version string
+	}{
+		{`Version: (\S+)`, "1.8.18-stable"},
+		{`Quorum Version: \S+`, "Quorum Version: 2.2.1"},
+		{"", "Geth\nVersion: 1.8.18-stable\nGit Commit: 1ff152f3\nQuorum Version: 2.2.1"},
+	}
+	for _, test := range tests {
+		nodeInfo := NodeInfoContainer{}
+		nodeInfo.VersionCmd = "geth version"
+		nodeInfo.VersionRegex = test.regex
+		version, err := nodeInfo.GetVersion(&testRunner{output: output})
+		if err != nil || version != test.version {
+			t.Fatalf("Version for regex %s should be %q, but is %q, error: %v", test.regex, test.version, version, err)
+		}
+	}
+
+	nodeInfo := NodeInfoContainer{}
+	nodeInfo.VersionCmd = "geth version"
+	nodeInfo.VersionRegex = `Vault (\S+)`
+	if _, err := nodeInfo.GetVersion(&testRunner{output: output}); err == nil {
+		t.Fatal("GetVersion should fail when the regex doesn't match")
+	}
+	if _, err := nodeInfo.GetVersion(&testRunner{err: errors.New("command not found")}); err == nil {
+		t.Fatal("GetVersion should fail when the command can't be run")
+	}
+}
+
+func TestVersionInfo_SetBeforeAfter(t *testing.T) {
+	versionInfo := NewVersionInfo()
+	if !versionInfo.Empty() {
+		t.Fatal("New version info should be empty")
+	}
+	versionInfo.SetBefore("node1", "quorum", "2.2.0")
+	versionInfo.SetAfter("node1", "quorum", "2.2.1")
+	data, err := json.Marshal(versionInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewVersionInfo()
+	if err = json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if version := loaded.Get("node1", "quorum"); version.Before != "2.2.0" || version.After != "2.2.1" {
+		t.Fatalf("Versions should be 2.2.0 and 2.2.1, but are %+v", version)
+	}
+}